@@ -4,13 +4,19 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
+	"multiplayer/internal/admin"
+	"multiplayer/internal/bot"
 	"multiplayer/internal/cli"
 	_ "multiplayer/internal/config"
 	"multiplayer/internal/game"
 	"multiplayer/internal/mcp"
 	"multiplayer/internal/simulation"
+	"multiplayer/internal/state"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
@@ -19,40 +25,155 @@ func main() {
 	var (
 		serverAddr string
 		remoteAddr string
+		wrap       bool
+		mode       string
+		ff         bool
+		rulesPath  string
+		dumpDir    string
+		room       string
+		roomCap    int
+		specCap    int
+		minPlayers int
+		password   string
+		accessPath string
+		spectate   bool
+		name       string
+		boardPath  string
+		bots       int
+		botTime    time.Duration
+		maxRooms   int
+		recordDir  string
+		replayPath string
+		srv        server
 	)
 	flag.StringVar(&serverAddr, "listen", "", "specify address to listen on")
 	flag.StringVar(&remoteAddr, "connect", "", "specify remote address for connecting to a server")
+	flag.BoolVar(&wrap, "wrap", false, "wrap entities around the edges of the world (server only)")
+	flag.StringVar(&mode, "mode", state.ModeCoop.String(), "specify game mode: coop, ffa or tdm (server only)")
+	flag.BoolVar(&ff, "friendly-fire", false, "let teammates hurt each other in tdm mode (server only)")
+	flag.StringVar(&rulesPath, "rules", "", "specify a JSON file of gameplay rules overriding the defaults (server only)")
+	flag.StringVar(&dumpDir, "desync-dump", "", "specify a directory to dump the first state failing its checksum to (client only)")
+	flag.BoolVar(&srv.adminStdin, "admin", false, "read admin commands from stdin (server only)")
+	flag.StringVar(&srv.adminSock, "admin-socket", "", "specify a Unix socket path to serve admin commands on (server only)")
+	flag.StringVar(&srv.metricsAddr, "metrics-addr", "", "specify an address to serve Prometheus metrics on at /metrics (server only)")
+	flag.StringVar(&room, "room", "", "specify the room to join, or leave empty to be assigned one (client only)")
+	flag.IntVar(&roomCap, "room-capacity", 8, "specify how many players fit in a room (server only)")
+	flag.IntVar(&specCap, "spectator-capacity", 16, "specify how many spectators may watch a room (server only)")
+	flag.BoolVar(&spectate, "spectate", false, "watch the room without a ship of your own (client only)")
+	flag.StringVar(&name, "name", "", "specify the nickname shown to the other players (client only)")
+	flag.IntVar(&minPlayers, "min-players", 0, "specify how many players AI pilots fill every room up to (server only)")
+	flag.StringVar(&password, "password", "", "specify the password the server asks clients for, or which to answer it with")
+	flag.StringVar(&accessPath, "access", "", "specify a JSON file to keep the banned and allowed addresses in (server only)")
+	flag.IntVar(&maxRooms, "max-rooms", 16, "specify how many rooms may be open at once (server only)")
+	flag.StringVar(&recordDir, "record", "", "specify a directory to record a replay of every room into (server only)")
+	flag.StringVar(&boardPath, "leaderboard", "", "specify a JSON file to keep the best scores and latest matches in (server only)")
+	flag.IntVar(&bots, "bots", 0, "specify a number of headless bots to connect instead of playing, for load testing (client only)")
+	flag.DurationVar(&botTime, "bot-duration", 0, "specify how long bots play before reporting, or 0 to play until interrupted")
+	flag.StringVar(&replayPath, "replay", "", "specify a replay file to play back instead of connecting to a server")
 	flag.Parse()
 
 	ctx, cancel := cli.NewSignalContext()
 	defer cancel()
 
 	if len(serverAddr) > 0 {
-		listenAndSimulate(ctx, serverAddr)
+		m, err := state.ParseMode(mode)
+		if err != nil {
+			slog.Error("failed to parse mode", "error", err)
+			os.Exit(1)
+		}
+		rules := state.DefaultRules()
+		if len(rulesPath) > 0 {
+			rules, err = state.LoadRules(rulesPath)
+			if err != nil {
+				slog.Error("failed to load rules", "error", err)
+				os.Exit(1)
+			}
+		}
+		srv.listenAndSimulate(ctx, serverAddr,
+			simulation.WithWrap(wrap),
+			simulation.WithMode(m),
+			simulation.WithFriendlyFire(ff),
+			simulation.WithRules(rules),
+			simulation.WithRoomCapacity(roomCap),
+			simulation.WithSpectatorCapacity(specCap),
+			simulation.WithMinPlayers(minPlayers),
+			simulation.WithPassword(password),
+			simulation.WithAccessFile(accessPath),
+			simulation.WithMaxRooms(maxRooms),
+			simulation.WithRecordDir(recordDir),
+			simulation.WithLeaderboard(boardPath))
+	} else if len(remoteAddr) > 0 && bots > 0 {
+		runBots(ctx, remoteAddr, bots, botTime, bot.WithRoom(room), bot.WithPassword(password))
 	} else if len(remoteAddr) > 0 {
-		connectAndRun(ctx, remoteAddr)
+		connectAndRun(ctx, remoteAddr, game.WithDesyncDump(dumpDir), game.WithRoom(room), game.WithSpectate(spectate), game.WithName(name), game.WithPassword(password))
+	} else if len(replayPath) > 0 {
+		playReplay(replayPath)
 	} else {
-		slog.Error("please specify either a -listen flag or a -connect flag")
+		slog.Error("please specify either a -listen flag, a -connect flag or a -replay flag")
 		os.Exit(1)
 	}
 }
 
-func listenAndSimulate(ctx context.Context, addr string) {
-	sim, err := simulation.Start(addr)
+// closeTimeout bounds how long the server takes to shut down once
+// interrupted, saving the leaderboard among others.
+const closeTimeout = 5 * time.Second
+
+// server holds what the server serves besides the game.
+type server struct {
+	adminStdin  bool
+	adminSock   string
+	metricsAddr string
+}
+
+func (srv server) listenAndSimulate(ctx context.Context, addr string, opts ...simulation.Option) {
+	sim, err := simulation.Start(addr, opts...)
 	if err != nil {
 		slog.Error("failed to instantiate simulation", "error", err)
 		return
 	}
 	defer func() {
+		// ctx is done by now, yet the matches under way are still to be saved.
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
 		err = sim.Close(ctx)
 		if err != nil {
 			slog.Error("failed to close simulation", "error", err)
 		}
 	}()
 
+	if srv.adminStdin {
+		go func() {
+			err := admin.Serve(ctx, os.Stdin, os.Stdout, sim)
+			if err != nil {
+				slog.Error("failed to serve admin commands on stdin", "error", err)
+			}
+		}()
+	}
+	if len(srv.adminSock) > 0 {
+		go func() {
+			err := admin.ListenUnix(ctx, srv.adminSock, sim)
+			if err != nil {
+				slog.Error("failed to serve admin commands on socket", "path", srv.adminSock, "error", err)
+			}
+		}()
+	}
+	if len(srv.metricsAddr) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", sim.Metrics())
+		httpServer := &http.Server{Addr: srv.metricsAddr, Handler: mux}
+		go func() {
+			err := httpServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve metrics", "address", srv.metricsAddr, "error", err)
+			}
+		}()
+		defer func() { _ = httpServer.Shutdown(ctx) }()
+		slog.Info("serving metrics", "address", srv.metricsAddr)
+	}
+
 	ebiten.SetWindowTitle("Asteroids [SERVER]")
 	ebiten.SetWindowSize(640, 360)
-	ebiten.SetTPS(30)
+	ebiten.SetTPS(simulation.TPS)
 	err = ebiten.RunGame(sim)
 	if err != nil {
 		slog.Error("failed to run simulation as an ebiten game", "error", err)
@@ -60,8 +181,8 @@ func listenAndSimulate(ctx context.Context, addr string) {
 	}
 }
 
-func connectAndRun(ctx context.Context, raddr string) {
-	g, err := game.Start(ctx, raddr)
+func connectAndRun(ctx context.Context, raddr string, opts ...game.Option) {
+	g, err := game.Start(ctx, raddr, opts...)
 	if err != nil {
 		slog.Error("failed to initialize game", "error", err)
 		return
@@ -81,4 +202,39 @@ func connectAndRun(ctx context.Context, raddr string) {
 		slog.Error("failed to run game as an ebiten game", "error", err)
 		return
 	}
+	if reason := g.CloseReason(); len(reason) > 0 {
+		slog.Warn("disconnected by the server", "reason", reason)
+	}
+}
+
+func runBots(ctx context.Context, raddr string, n int, d time.Duration, opts ...bot.Option) {
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	report, err := bot.Run(ctx, raddr, n, opts...)
+	if err != nil {
+		slog.Error("failed to run bots", "error", err)
+		return
+	}
+	fmt.Println(report)
+}
+
+func playReplay(path string) {
+	r, err := game.LoadReplay(path)
+	if err != nil {
+		slog.Error("failed to load replay", "error", err)
+		return
+	}
+	defer r.Close()
+
+	ebiten.SetWindowTitle("Asteroids [REPLAY]")
+	ebiten.SetWindowSize(640, 360)
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+	err = ebiten.RunGame(r)
+	if err != nil {
+		slog.Error("failed to run replay as an ebiten game", "error", err)
+		return
+	}
 }
@@ -0,0 +1,136 @@
+// Package admin lets operators manage a running server with line-based
+// commands, such as "kick 3", read from stdin or a Unix socket. Every command
+// is answered with a single line of JSON so that it can be scripted.
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+// Command is a line sent by an operator, split into its words.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// ParseCommand splits line into a command.
+func ParseCommand(line string) (Command, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Command{}, errors.New("empty command")
+	}
+	return Command{Name: fields[0], Args: fields[1:]}, nil
+}
+
+// Result is the answer to a command.
+type Result struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Data  any    `json:"data,omitempty"`
+}
+
+// Executor carries out commands, returning data to be encoded as JSON.
+type Executor interface {
+	Execute(ctx context.Context, cmd Command) (any, error)
+}
+
+// Serve executes every line of r as a command until r runs out, writing the
+// results to w as JSON lines. Blank lines are skipped.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, exec Executor) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		var res Result
+		cmd, err := ParseCommand(line)
+		if err == nil {
+			res.Data, err = exec.Execute(ctx, cmd)
+		}
+		if err != nil {
+			res = Result{OK: false, Error: err.Error()}
+		} else {
+			res.OK = true
+		}
+
+		err = enc.Encode(res)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ListenUnix serves commands to everyone connecting to the Unix socket at
+// path, which only the owner may connect to, until ctx is done. A socket left
+// at path by a server which did not shut down cleanly is replaced.
+func ListenUnix(ctx context.Context, path string, exec Executor) error {
+	err := removeStale(path)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path, 0o600)
+	if err != nil {
+		return errors.Join(err, ln.Close())
+	}
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			err := Serve(ctx, conn, conn, exec)
+			if err != nil {
+				slog.Warn("failed to serve admin connection", "error", err)
+			}
+		}()
+	}
+}
+
+// removeStale removes the socket at path unless a listener still holds it.
+// Anything else at path is left for net.Listen to fail on.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %q: in use by another server", path)
+	}
+	return os.Remove(path)
+}
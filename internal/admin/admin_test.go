@@ -0,0 +1,93 @@
+package admin_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"multiplayer/internal/admin"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type executorFunc func(ctx context.Context, cmd admin.Command) (any, error)
+
+func (f executorFunc) Execute(ctx context.Context, cmd admin.Command) (any, error) {
+	return f(ctx, cmd)
+}
+
+func TestServe(t *testing.T) {
+	exec := executorFunc(func(ctx context.Context, cmd admin.Command) (any, error) {
+		switch cmd.Name {
+		case "echo":
+			return cmd.Args, nil
+		case "pause":
+			return nil, nil
+		default:
+			return nil, errors.New("unknown command")
+		}
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"data", "echo hello   world\n", `{"ok":true,"data":["hello","world"]}`},
+		{"no data", "pause\n", `{"ok":true}`},
+		{"error", "bogus\n", `{"ok":false,"error":"unknown command"}`},
+		{"blank lines", "\n  \npause", `{"ok":true}`},
+		{"several", "pause\nbogus\n", `{"ok":true}` + "\n" + `{"ok":false,"error":"unknown command"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := admin.Serve(context.Background(), strings.NewReader(tt.input), &out, exec)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected+"\n", out.String())
+		})
+	}
+}
+
+func TestListenUnix_staleSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// A server which crashed leaves its socket behind.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	stale.SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+
+	exec := executorFunc(func(context.Context, admin.Command) (any, error) { return nil, nil })
+	done := make(chan error, 1)
+	go func() { done <- admin.ListenUnix(ctx, path, exec) }()
+
+	var conn net.Conn
+	ok := assert.Eventually(t, func() bool {
+		conn, err = net.Dial("unix", path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("pause\n"))
+	assert.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ok":true}`+"\n", line)
+
+	assert.Error(t, admin.ListenUnix(ctx, path, exec), "a socket in use must be left alone")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
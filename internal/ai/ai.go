@@ -0,0 +1,193 @@
+// Package ai steers the pilots the server adds to rooms lacking players. A
+// pilot looks at the same state as everyone else and presses the same keys a
+// player would: it dodges whatever is about to hit it, and otherwise turns
+// towards the nearest target, closing in on it and firing once lined up.
+package ai
+
+import (
+	"math"
+	"multiplayer/internal/state"
+)
+
+const (
+	// horizon is how far ahead in seconds pilots look for collisions.
+	horizon = 1.0
+	// margin is how closely in pixels pilots let things pass by them.
+	margin = 30.0
+	// aimTolerance is how far off in radians pilots fire at their target.
+	aimTolerance = 0.1
+	// turnTolerance is how far off in radians pilots stop turning, which
+	// keeps them from wobbling around their heading.
+	turnTolerance = 0.05
+	// closeRange is how near in pixels pilots stop thrusting towards their
+	// target, rather than ramming it.
+	closeRange = 300.0
+	// cruiseSpeed is how fast in pixels a second pilots wander with nothing
+	// to shoot at.
+	cruiseSpeed = 100.0
+)
+
+// Steer returns the input of the pilot playing the player with id in s.
+func Steer(s state.State, id uint16) state.Input {
+	var me state.Player
+	found := false
+	for _, player := range s.Players {
+		if player.ID == id {
+			me, found = player, true
+			break
+		}
+	}
+	if !found || me.Dead {
+		return state.Input{}
+	}
+
+	if away, ok := threat(s, me); ok {
+		return steerTowards(me, away, true, false)
+	}
+
+	target, ok := target(s, me)
+	if !ok {
+		// Wander towards the middle of the world, where things happen.
+		center := state.Vec2{X: s.World.Width / 2, Y: s.World.Height / 2}
+		d := s.World.Delta(me.Trans, center)
+		return steerTowards(me, d, d.Magnitude() > closeRange && me.Vel.Magnitude() < cruiseSpeed, false)
+	}
+	return steerTowards(me, target, target.Magnitude() > closeRange, true)
+}
+
+// steerTowards turns me towards the direction d, thrusting forward if thrust
+// and firing once lined up if fire.
+func steerTowards(me state.Player, d state.Vec2, thrust, fire bool) state.Input {
+	diff := angleDiff(heading(d), me.Rotation)
+	return state.Input{
+		Left:  diff < -turnTolerance,
+		Down:  false,
+		Up:    thrust,
+		Right: diff > turnTolerance,
+		Space: fire && math.Abs(diff) < aimTolerance,
+	}
+}
+
+// heading returns the rotation of a ship facing the direction d. Ships with no
+// rotation face up, and turn clockwise as their rotation grows.
+func heading(d state.Vec2) float64 {
+	return math.Atan2(d.X, -d.Y)
+}
+
+// angleDiff returns how far to turn from b to a, between -π and π.
+func angleDiff(a, b float64) float64 {
+	d := math.Mod(a-b+math.Pi, 2*math.Pi)
+	if d < 0 {
+		d += 2 * math.Pi
+	}
+	return d - math.Pi
+}
+
+// hazard is something which could hit a player.
+type hazard struct {
+	shape state.Circle
+	vel   state.Vec2
+}
+
+// threat returns the direction in which me had better flee from whatever is
+// going to hit them the soonest, if anything is.
+func threat(s state.State, me state.Player) (state.Vec2, bool) {
+	if me.Invulnerable > 0 {
+		return state.Vec2{}, false
+	}
+
+	var hazards []hazard
+	for _, asteroid := range s.Asteroids {
+		hazards = append(hazards, hazard{shape: asteroid.Shape(), vel: asteroid.Vel})
+	}
+	for _, ufo := range s.UFOs {
+		hazards = append(hazards, hazard{shape: ufo.Shape(), vel: ufo.Vel})
+	}
+	for _, bullet := range s.Bullets {
+		if hostile(s, me, bullet.Owner) {
+			hazards = append(hazards, hazard{shape: bullet.Shape(), vel: bullet.Vel})
+		}
+	}
+
+	soonest := math.Inf(1)
+	var away state.Vec2
+	for _, h := range hazards {
+		p := s.World.Delta(me.Trans, h.shape.Center)
+		v := h.vel.Sub(me.Vel)
+		t := 0.0
+		if speed := v.Dot(v); speed > 0 {
+			t = min(horizon, max(0, -p.Dot(v)/speed))
+		}
+		closest := p.Add(v.Mul(t))
+		if closest.Magnitude() > h.shape.Radius+state.PlayerRadius+margin || t >= soonest {
+			continue
+		}
+		soonest = t
+		away = closest.Mul(-1)
+		if away == (state.Vec2{}) {
+			// Head-on: flee sideways.
+			away = state.Vec2{X: -v.Y, Y: v.X}
+		}
+	}
+	return away, !math.IsInf(soonest, 1)
+}
+
+// hostile reports whether the bullets of owner can hurt me.
+func hostile(s state.State, me state.Player, owner uint16) bool {
+	if owner == me.ID {
+		return false
+	}
+	if owner == state.OwnerHostile {
+		return true
+	}
+	switch s.Mode {
+	case state.ModeFreeForAll:
+		return true
+	case state.ModeTeamDeathmatch:
+		if s.FriendlyFire {
+			return true
+		}
+		for _, player := range s.Players {
+			if player.ID == owner {
+				return player.Team != me.Team
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// target returns where to aim to hit the nearest asteroid, UFO or opponent
+// of me, leading them by the time bullets take to get there.
+func target(s state.State, me state.Player) (state.Vec2, bool) {
+	var targets []hazard
+	for _, asteroid := range s.Asteroids {
+		targets = append(targets, hazard{shape: asteroid.Shape(), vel: asteroid.Vel})
+	}
+	for _, ufo := range s.UFOs {
+		targets = append(targets, hazard{shape: ufo.Shape(), vel: ufo.Vel})
+	}
+	for _, player := range s.Players {
+		if player.Dead || player.Invulnerable > 0 || !hostile(s, player, me.ID) {
+			continue
+		}
+		targets = append(targets, hazard{shape: player.Shape(), vel: player.Vel})
+	}
+
+	nearest := math.Inf(1)
+	var aim state.Vec2
+	for _, t := range targets {
+		p := s.World.Delta(me.Trans, t.shape.Center)
+		dist := p.Magnitude()
+		if dist >= nearest {
+			continue
+		}
+		nearest = dist
+		aim = p
+		if s.Rules.BulletSpeed > 0 {
+			aim = p.Add(t.vel.Sub(me.Vel).Mul(dist / s.Rules.BulletSpeed))
+		}
+	}
+	return aim, !math.IsInf(nearest, 1)
+}
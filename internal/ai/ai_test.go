@@ -0,0 +1,102 @@
+package ai_test
+
+import (
+	"math"
+	"multiplayer/internal/ai"
+	"multiplayer/internal/state"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pilotState returns a state with a single vulnerable player at rest in the
+// middle of the world, facing up, and the given asteroids.
+func pilotState(asteroids ...state.Asteroid) state.State {
+	s := state.Init()
+	s.AddPlayer("ai")
+	s.Players[0].Trans = state.Vec2{X: s.World.Width / 2, Y: s.World.Height / 2}
+	s.Players[0].Rotation = 0
+	s.Players[0].Invulnerable = 0
+	s.Asteroids = asteroids
+	s.UFOs = nil
+	s.Bullets = nil
+	return s
+}
+
+// asteroidAt returns an asteroid at rest at offset from the middle of the
+// world of s.
+func asteroidAt(s state.State, offset state.Vec2, vel state.Vec2) state.Asteroid {
+	center := state.Vec2{X: s.World.Width / 2, Y: s.World.Height / 2}
+	return state.Asteroid{ID: 1, Size: state.AsteroidSmall, Trans: center.Add(offset), Vel: vel}
+}
+
+func TestSteer(t *testing.T) {
+	base := pilotState()
+	tests := []struct {
+		name      string
+		asteroids []state.Asteroid
+		rotation  float64
+		want      state.Input
+	}{
+		{
+			name:      "fires at an asteroid ahead",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: 0, Y: -200}, state.Vec2{})},
+			want:      state.Input{Space: true},
+		},
+		{
+			name:      "closes in on a far asteroid",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: 0, Y: -600}, state.Vec2{})},
+			want:      state.Input{Up: true, Space: true},
+		},
+		{
+			name:      "turns right towards an asteroid on the right",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: 200, Y: 0}, state.Vec2{})},
+			want:      state.Input{Right: true},
+		},
+		{
+			name:      "turns left towards an asteroid on the left",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: -200, Y: 0}, state.Vec2{})},
+			want:      state.Input{Left: true},
+		},
+		{
+			name:      "aims at the nearest asteroid",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: -250, Y: 0}, state.Vec2{}), asteroidAt(base, state.Vec2{X: 200, Y: 0}, state.Vec2{})},
+			want:      state.Input{Right: true},
+		},
+		{
+			name: "flees an asteroid about to hit",
+			// Coming down from above and slightly to the left, so the way out
+			// is to the right.
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: -20, Y: -300}, state.Vec2{X: 0, Y: 400})},
+			want:      state.Input{Right: true, Up: true},
+		},
+		{
+			name:      "flees straight away once facing the way out",
+			asteroids: []state.Asteroid{asteroidAt(base, state.Vec2{X: -20, Y: -300}, state.Vec2{X: 0, Y: 400})},
+			rotation:  math.Pi / 2,
+			want:      state.Input{Up: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := pilotState(tt.asteroids...)
+			s.Players[0].Rotation = tt.rotation
+			assert.Equal(t, tt.want, ai.Steer(s, s.Players[0].ID))
+		})
+	}
+}
+
+func TestSteer_dead(t *testing.T) {
+	s := pilotState()
+	s.Asteroids = []state.Asteroid{asteroidAt(s, state.Vec2{X: 0, Y: -200}, state.Vec2{})}
+	s.Players[0].Dead = true
+	assert.Equal(t, state.Input{}, ai.Steer(s, s.Players[0].ID))
+	assert.Equal(t, state.Input{}, ai.Steer(s, 99), "missing players do nothing")
+}
+
+func TestSteer_wander(t *testing.T) {
+	s := pilotState()
+	s.Players[0].Trans = state.Vec2{X: 100, Y: s.World.Height / 2}
+	s.Players[0].Rotation = math.Pi / 2 // facing right, towards the middle
+	assert.Equal(t, state.Input{Up: true}, ai.Steer(s, s.Players[0].ID))
+}
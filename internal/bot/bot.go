@@ -0,0 +1,356 @@
+// Package bot runs headless clients against a server to measure how well it
+// copes with many players. Bots steer at random, sending their inputs the way
+// the game does, and decode every state they receive.
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"multiplayer/internal/jitter"
+	"multiplayer/internal/mcp"
+	"multiplayer/internal/state"
+	"sync"
+	"time"
+)
+
+// InputRate is how many times a second bots send their inputs, which is as
+// often as the game updates.
+const InputRate = 60
+
+// steerInterval is how long bots hold on to the keys they picked.
+const steerInterval = 500 * time.Millisecond
+
+// Report sums up how the bots fared.
+type Report struct {
+	Bots     int
+	Duration time.Duration
+
+	// Snapshots counts the states received, of which DecodeErrors failed to
+	// decode and Desyncs decoded to a different checksum. Lost counts those
+	// never received, going by the gaps between their indices.
+	Snapshots    int
+	Lost         int
+	DecodeErrors int
+	Desyncs      int
+	// SnapshotBytes is the total size of the states received.
+	SnapshotBytes int
+	MaxSnapshot   int
+
+	// RTT is the mean of the round-trip times measured every second.
+	RTT    time.Duration
+	MaxRTT time.Duration
+
+	rttSum   time.Duration
+	rttCount int
+}
+
+// Loss returns the share of the states which were never received.
+func (r Report) Loss() float64 {
+	if r.Snapshots+r.Lost == 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(r.Snapshots+r.Lost)
+}
+
+// MeanSnapshot returns the mean size in bytes of the states received.
+func (r Report) MeanSnapshot() int {
+	if r.Snapshots == 0 {
+		return 0
+	}
+	return r.SnapshotBytes / r.Snapshots
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d bots for %s: %d snapshots, %.2f%% lost, %d decode errors, %d desyncs, "+
+		"%d B mean and %d B max snapshot size, %s mean and %s max RTT",
+		r.Bots, r.Duration.Round(time.Second), r.Snapshots, 100*r.Loss(), r.DecodeErrors, r.Desyncs,
+		r.MeanSnapshot(), r.MaxSnapshot, r.RTT.Round(time.Microsecond), r.MaxRTT.Round(time.Microsecond))
+}
+
+// add adds the figures of other to r.
+func (r *Report) add(other Report) {
+	r.Bots += other.Bots
+	r.Snapshots += other.Snapshots
+	r.Lost += other.Lost
+	r.DecodeErrors += other.DecodeErrors
+	r.Desyncs += other.Desyncs
+	r.SnapshotBytes += other.SnapshotBytes
+	r.MaxSnapshot = max(r.MaxSnapshot, other.MaxSnapshot)
+	r.MaxRTT = max(r.MaxRTT, other.MaxRTT)
+	r.rttSum += other.rttSum
+	r.rttCount += other.rttCount
+	if r.rttCount > 0 {
+		r.RTT = r.rttSum / time.Duration(r.rttCount)
+	}
+}
+
+type Option func(opts *options) error
+
+type options struct {
+	room     string
+	seed     uint64
+	password string
+}
+
+// WithRoom sends every bot to the room called room rather than whichever the
+// server sees fit.
+func WithRoom(room string) Option {
+	return func(opts *options) error {
+		opts.room = room
+		return nil
+	}
+}
+
+// WithSeed seeds the random steering of the bots, for runs to be repeated.
+func WithSeed(seed uint64) Option {
+	return func(opts *options) error {
+		opts.seed = seed
+		return nil
+	}
+}
+
+// WithPassword has the bots join a server which asks for password.
+func WithPassword(password string) Option {
+	return func(opts *options) error {
+		opts.password = password
+		return nil
+	}
+}
+
+// Run has n bots play on the server at raddr until ctx is done, and reports
+// how they fared.
+func Run(ctx context.Context, raddr string, n int, opts ...Option) (Report, error) {
+	o := options{
+		room:     "",
+		seed:     uint64(time.Now().UnixNano()),
+		password: "",
+	}
+	var optErrs []error
+	for _, opt := range opts {
+		optErrs = append(optErrs, opt(&o))
+	}
+	if err := errors.Join(optErrs...); err != nil {
+		return Report{}, err
+	}
+
+	bots := make([]*bot, 0, n)
+	closeAll := func() error {
+		var errs []error
+		for _, b := range bots {
+			err := b.sess.Close(context.Background())
+			if !errors.Is(err, mcp.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	for i := range n {
+		b, err := dial(ctx, raddr, i, o)
+		if err != nil {
+			return Report{}, errors.Join(fmt.Errorf("bot %d: %w", i, err), closeAll())
+		}
+		bots = append(bots, b)
+	}
+	slog.Info("bots joined", "bots", n, "address", raddr)
+
+	start := time.Now()
+	reports := make([]Report, n)
+	var wg sync.WaitGroup
+	for i, b := range bots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i] = b.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Duration: time.Since(start)}
+	for _, r := range reports {
+		report.add(r)
+	}
+	return report, closeAll()
+}
+
+// bot is a headless client.
+type bot struct {
+	sess *mcp.Session
+	rng  *rand.Rand
+
+	inputBuffer     jitter.Buffer
+	inputBufferLock sync.Mutex
+
+	// rules are only used by receiveLoop, to decode states.
+	rules state.Rules
+
+	// report is filled in by receiveLoop, except for the round-trip times
+	// which run measures.
+	report Report
+}
+
+func dial(ctx context.Context, raddr string, i int, o options) (*bot, error) {
+	var join bytes.Buffer
+	state.Join{Room: o.room, Spectate: false, Name: fmt.Sprintf("bot-%d", i), Version: "bot"}.Encode(&join)
+	sess, err := mcp.Dial(ctx, raddr,
+		mcp.WithJoinData(join.Bytes()),
+		mcp.WithPassword(o.password))
+	if err != nil {
+		return nil, err
+	}
+	return &bot{
+		sess:            sess,
+		rng:             rand.New(rand.NewPCG(o.seed, uint64(i))),
+		inputBuffer:     jitter.Buffer{},
+		inputBufferLock: sync.Mutex{},
+		rules:           state.DefaultRules(),
+		report:          Report{Bots: 1},
+	}, nil
+}
+
+// run sends inputs until ctx is done or the session is closed, and reports
+// what was received meanwhile.
+func (b *bot) run(ctx context.Context) Report {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		defer cancel()
+		b.receiveLoop(ctx)
+	}()
+
+	inputTicker := time.NewTicker(time.Second / InputRate)
+	defer inputTicker.Stop()
+	steerTicker := time.NewTicker(steerInterval)
+	defer steerTicker.Stop()
+	rttTicker := time.NewTicker(time.Second)
+	defer rttTicker.Stop()
+
+	input := b.steer()
+	for {
+		select {
+		case <-ctx.Done():
+			<-received
+			return b.report
+		case <-steerTicker.C:
+			input = b.steer()
+		case <-rttTicker.C:
+			if rtt := b.sess.RTT(); rtt > 0 {
+				b.report.rttSum += rtt
+				b.report.rttCount++
+				b.report.RTT = b.report.rttSum / time.Duration(b.report.rttCount)
+				b.report.MaxRTT = max(b.report.MaxRTT, rtt)
+			}
+		case <-inputTicker.C:
+			b.sendInput(input)
+		}
+	}
+}
+
+// steer picks the keys held down next. Bots thrust forward more often than
+// not and fire all the time, like players tend to.
+func (b *bot) steer() state.Input {
+	turn := b.rng.IntN(3)
+	return state.Input{
+		Left:  turn == 1,
+		Down:  false,
+		Up:    b.rng.IntN(3) > 0,
+		Right: turn == 2,
+		Space: true,
+	}
+}
+
+func (b *bot) sendInput(input state.Input) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(0) /* type = inputs */)
+	b.inputBufferLock.Lock()
+	b.inputBuffer.Append(input)
+	b.inputBuffer.Encode(&buf)
+	b.inputBufferLock.Unlock()
+	_ = b.sess.TrySend(buf.Bytes())
+}
+
+func (b *bot) receiveLoop(ctx context.Context) {
+	var lastIndex uint32
+	received := false
+	for {
+		data, err := b.sess.Receive(ctx)
+		if errors.Is(err, mcp.ErrClosed) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		r := bytes.NewReader(data)
+		var typ uint16
+		err = binary.Read(r, binary.BigEndian, &typ)
+		if err != nil {
+			b.report.DecodeErrors++
+			continue
+		}
+
+		switch typ {
+		case 0: // input ack
+			var index uint32
+			err = binary.Read(r, binary.BigEndian, &index)
+			if err != nil {
+				b.report.DecodeErrors++
+				continue
+			}
+			b.inputBufferLock.Lock()
+			b.inputBuffer.DiscardUntil(index)
+			b.inputBufferLock.Unlock()
+
+		case 1: // state
+			b.report.Snapshots++
+			b.report.SnapshotBytes += len(data)
+			b.report.MaxSnapshot = max(b.report.MaxSnapshot, len(data))
+
+			var index, checksum uint32
+			err = binary.Read(r, binary.BigEndian, &index)
+			if err == nil {
+				err = binary.Read(r, binary.BigEndian, &checksum)
+			}
+			if err != nil {
+				b.report.DecodeErrors++
+				continue
+			}
+			if received && index > lastIndex {
+				b.report.Lost += int(index - lastIndex - 1)
+			}
+			if !received || index > lastIndex {
+				lastIndex = index
+				received = true
+			}
+
+			var s state.State
+			s.SetRules(b.rules)
+			err = s.Decode(r)
+			if err != nil {
+				b.report.DecodeErrors++
+				continue
+			}
+			if s.Checksum() != checksum {
+				b.report.Desyncs++
+			}
+
+		case 2: // welcome
+			var playerID uint16
+			err = binary.Read(r, binary.BigEndian, &playerID)
+			var rules state.Rules
+			if err == nil {
+				err = rules.Decode(r)
+			}
+			if err != nil {
+				b.report.DecodeErrors++
+				continue
+			}
+			b.rules = rules
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package bot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"multiplayer/internal/bot"
+	"multiplayer/internal/mcp"
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	const tick = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(context.Background()) }()
+
+	// The server welcomes the bot, then sends states 0 to 5 but 3, and one
+	// state that does not decode, a tick apart as sessions only buffer a
+	// single datagram.
+	joins := make(chan state.Join, 1)
+	go func() {
+		sess, err := server.Accept(ctx)
+		if err != nil {
+			return
+		}
+		var join state.Join
+		_ = join.Decode(bytes.NewReader(sess.JoinData()))
+		joins <- join
+
+		s := state.Init()
+		var welcome bytes.Buffer
+		_ = binary.Write(&welcome, binary.BigEndian, uint16(2))
+		_ = binary.Write(&welcome, binary.BigEndian, uint16(1))
+		s.Rules.Encode(&welcome)
+		_ = sess.Send(ctx, welcome.Bytes())
+		time.Sleep(tick)
+
+		for _, index := range []uint32{0, 1, 2, 4, 5} {
+			var msg bytes.Buffer
+			_ = binary.Write(&msg, binary.BigEndian, uint16(1))
+			_ = binary.Write(&msg, binary.BigEndian, index)
+			_ = binary.Write(&msg, binary.BigEndian, s.Checksum())
+			s.Encode(&msg)
+			_ = sess.Send(ctx, msg.Bytes())
+			time.Sleep(tick)
+		}
+		var corrupt bytes.Buffer
+		_ = binary.Write(&corrupt, binary.BigEndian, uint16(1))
+		_ = binary.Write(&corrupt, binary.BigEndian, uint32(6))
+		_ = sess.Send(ctx, corrupt.Bytes())
+	}()
+
+	report, err := bot.Run(ctx, server.LocalAddr().String(), 1, bot.WithRoom("load"), bot.WithSeed(1))
+	assert.NoError(t, err)
+
+	select {
+	case join := <-joins:
+		assert.Equal(t, "load", join.Room)
+		assert.Equal(t, "bot-0", join.Name)
+	default:
+		t.Fatal("bot never joined")
+	}
+	assert.Equal(t, 1, report.Bots)
+	assert.Equal(t, 6, report.Snapshots)
+	assert.Equal(t, 1, report.Lost)
+	assert.Equal(t, 1, report.DecodeErrors)
+	assert.Equal(t, 0, report.Desyncs)
+	assert.InDelta(t, 1.0/7, report.Loss(), 1e-9)
+	assert.True(t, report.MaxSnapshot >= report.MeanSnapshot())
+}
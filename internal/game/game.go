@@ -8,15 +8,20 @@ import (
 	"fmt"
 	_ "image/png"
 	"log/slog"
-	"multiplayer/assets"
 	"multiplayer/internal/jitter"
+	"multiplayer/internal/leaderboard"
 	"multiplayer/internal/mcp"
+	"multiplayer/internal/render"
 	"multiplayer/internal/state"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 type snapshot struct {
@@ -30,6 +35,44 @@ type Game struct {
 	inputBuffer     jitter.Buffer
 	inputBufferLock sync.Mutex
 
+	// playerID and rules are given by the server as we join, along with the
+	// names of the players. Until then, rules are the defaults. namesVersion
+	// is the version of names, which the server counts up as they change.
+	playerID     uint16
+	rules        state.Rules
+	names        state.Names
+	namesVersion uint32
+	welcomeLock  sync.Mutex
+
+	camera render.Camera
+
+	// spectate is set for games joined without a ship. They follow the
+	// player with followID, or see the whole world in the overview.
+	spectate bool
+	followID uint16
+	overview bool
+
+	// desyncs counts the snapshots which did not decode to the state the
+	// server encoded; see state.State.Checksum.
+	desyncs int
+	dumpDir string
+	dumped  bool
+
+	// chatLog holds the latest lines of the chat with the time they arrived,
+	// shown until they fade out after chatDuration.
+	chatLog  []chatEntry
+	chatLock sync.Mutex
+	// typing is set while a line of chat is being typed into draft.
+	typing bool
+	draft  []rune
+
+	// top holds the best scores as last sent by the server, shown over the
+	// game while showTop is set.
+	top       []leaderboard.Entry
+	topLoaded bool
+	topLock   sync.Mutex
+	showTop   bool
+
 	state          state.State
 	prevSnapshot   snapshot
 	nextSnapshot   snapshot
@@ -37,8 +80,88 @@ type Game struct {
 	snapshotLock   sync.Mutex
 }
 
-func Start(ctx context.Context, raddr string) (*Game, error) {
-	sess, err := mcp.Dial(ctx, raddr, mcp.WithLogger(slog.Default()))
+type chatEntry struct {
+	chat state.Chat
+	t    time.Time
+}
+
+type Option func(opts *options) error
+
+type options struct {
+	dumpDir  string
+	room     string
+	spectate bool
+	name     string
+	password string
+}
+
+// WithRoom asks the server for the room called room rather than whichever
+// it sees fit.
+func WithRoom(room string) Option {
+	return func(opts *options) error {
+		opts.room = room
+		return nil
+	}
+}
+
+// WithDesyncDump makes the game write the snapshot as received from the
+// server and as decoded by the client to dir on the first checksum mismatch,
+// for comparing the two.
+func WithDesyncDump(dir string) Option {
+	return func(opts *options) error {
+		opts.dumpDir = dir
+		return nil
+	}
+}
+
+// WithSpectate joins as a spectator, watching the others play without a ship
+// of our own.
+func WithSpectate(spectate bool) Option {
+	return func(opts *options) error {
+		opts.spectate = spectate
+		return nil
+	}
+}
+
+// WithName sets the nickname shown to the other players. The server may
+// number it if someone in the room already goes by it.
+func WithName(name string) Option {
+	return func(opts *options) error {
+		opts.name = name
+		return nil
+	}
+}
+
+// WithPassword joins a server which asks for password.
+func WithPassword(password string) Option {
+	return func(opts *options) error {
+		opts.password = password
+		return nil
+	}
+}
+
+func Start(ctx context.Context, raddr string, opts ...Option) (*Game, error) {
+	o := options{
+		dumpDir:  "",
+		room:     "",
+		spectate: false,
+		name:     "",
+		password: "",
+	}
+	var optErrs []error
+	for _, opt := range opts {
+		optErrs = append(optErrs, opt(&o))
+	}
+	if err := errors.Join(optErrs...); err != nil {
+		return nil, err
+	}
+
+	var join bytes.Buffer
+	state.Join{Room: o.room, Spectate: o.spectate, Name: o.name, Version: version()}.Encode(&join)
+	sess, err := mcp.Dial(ctx, raddr,
+		mcp.WithLogger(slog.Default()),
+		mcp.WithJoinData(join.Bytes()),
+		mcp.WithPassword(o.password))
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +170,25 @@ func Start(ctx context.Context, raddr string) (*Game, error) {
 		sess:            sess,
 		inputBuffer:     jitter.Buffer{},
 		inputBufferLock: sync.Mutex{},
+		playerID:        0,
+		rules:           state.DefaultRules(),
+		names:           state.Names{},
+		welcomeLock:     sync.Mutex{},
+		camera:          render.Camera{},
+		spectate:        o.spectate,
+		followID:        0,
+		overview:        false,
+		desyncs:         0,
+		dumpDir:         o.dumpDir,
+		dumped:          false,
+		chatLog:         nil,
+		chatLock:        sync.Mutex{},
+		typing:          false,
+		draft:           nil,
+		top:             nil,
+		topLoaded:       false,
+		topLock:         sync.Mutex{},
+		showTop:         false,
 		state:           state.State{},
 		lastStateIndex:  0,
 		prevSnapshot:    snapshot{},
@@ -57,6 +199,16 @@ func Start(ctx context.Context, raddr string) (*Game, error) {
 	return g, nil
 }
 
+// version returns the version of the module the client was built from, as
+// told to the server when joining.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return info.Main.Version
+}
+
 func (g *Game) receiveLoop(ctx context.Context) {
 	for {
 		data, err := g.sess.Receive(ctx)
@@ -99,13 +251,26 @@ func (g *Game) receiveLoop(ctx context.Context) {
 			if index <= g.lastStateIndex {
 				continue
 			}
+			var checksum uint32
+			err = binary.Read(r, binary.BigEndian, &checksum)
+			if err != nil {
+				slog.Warn("failed to read state checksum", "error", err)
+				continue
+			}
+			encoded := data[len(data)-r.Len():]
 
 			var s state.State
+			g.welcomeLock.Lock()
+			s.SetRules(g.rules)
+			g.welcomeLock.Unlock()
 			err = s.Decode(r)
 			if err != nil {
 				slog.Warn("failed to unmarshal state", "error", err)
 				continue
 			}
+			if s.Checksum() != checksum {
+				g.desync(index, encoded, s)
+			}
 			g.snapshotLock.Lock()
 			g.prevSnapshot = g.nextSnapshot
 			g.nextSnapshot = snapshot{
@@ -114,88 +279,202 @@ func (g *Game) receiveLoop(ctx context.Context) {
 			}
 			g.snapshotLock.Unlock()
 			g.lastStateIndex = index
+
+		case 2: // welcome
+			var playerID uint16
+			err = binary.Read(r, binary.BigEndian, &playerID)
+			if err != nil {
+				slog.Warn("failed to read player ID", "error", err)
+				continue
+			}
+			var rules state.Rules
+			err = rules.Decode(r)
+			if err != nil {
+				slog.Warn("failed to unmarshal rules", "error", err)
+				continue
+			}
+			var namesVersion uint32
+			err = binary.Read(r, binary.BigEndian, &namesVersion)
+			if err != nil {
+				slog.Warn("failed to read names version", "error", err)
+				continue
+			}
+			g.welcomeLock.Lock()
+			g.playerID = playerID
+			g.rules = rules
+			stale := namesVersion != g.namesVersion
+			g.welcomeLock.Unlock()
+			if stale {
+				g.queryNames()
+			}
+
+		case 4: // names
+			var namesVersion uint32
+			err = binary.Read(r, binary.BigEndian, &namesVersion)
+			if err != nil {
+				slog.Warn("failed to read names version", "error", err)
+				continue
+			}
+			var names state.Names
+			err = names.Decode(r)
+			if err != nil {
+				slog.Warn("failed to unmarshal names", "error", err)
+				continue
+			}
+			g.welcomeLock.Lock()
+			// Names overtaken on the way by newer ones are out of date.
+			if int32(namesVersion-g.namesVersion) >= 0 {
+				g.names = names
+				g.namesVersion = namesVersion
+			}
+			g.welcomeLock.Unlock()
+
+		case 5: // chat
+			var chat state.Chat
+			err = chat.Decode(r)
+			if err != nil {
+				slog.Warn("failed to unmarshal chat", "error", err)
+				continue
+			}
+			slog.Info("chat", "name", chat.Name, "text", chat.Text)
+			g.chatLock.Lock()
+			g.chatLog = append(g.chatLog, chatEntry{chat: chat, t: time.Now()})
+			if len(g.chatLog) > chatLogSize {
+				g.chatLog = g.chatLog[len(g.chatLog)-chatLogSize:]
+			}
+			g.chatLock.Unlock()
+
+		case 6: // leaderboard
+			top, err := leaderboard.Decode(r)
+			if err != nil {
+				slog.Warn("failed to unmarshal leaderboard", "error", err)
+				continue
+			}
+			g.topLock.Lock()
+			g.top = top
+			g.topLoaded = true
+			g.topLock.Unlock()
 		}
 	}
 }
 
+// desync reports that the state at index, which the server sent as encoded,
+// decoded to s with a different checksum.
+func (g *Game) desync(index uint32, encoded []byte, s state.State) {
+	g.desyncs++
+	slog.Warn("state checksum mismatch", "index", index, "desyncs", g.desyncs)
+	if len(g.dumpDir) == 0 || g.dumped {
+		return
+	}
+	g.dumped = true
+
+	var decoded bytes.Buffer
+	s.Encode(&decoded)
+	prefix := filepath.Join(g.dumpDir, fmt.Sprintf("desync-%d", index))
+	err := errors.Join(
+		os.MkdirAll(g.dumpDir, 0o755),
+		os.WriteFile(prefix+"-server.bin", encoded, 0o644),
+		os.WriteFile(prefix+"-client.bin", decoded.Bytes(), 0o644),
+		os.WriteFile(prefix+"-client.txt", fmt.Appendf(nil, "%+v\n", s), 0o644),
+	)
+	if err != nil {
+		slog.Warn("failed to dump desynced state", "error", err)
+		return
+	}
+	slog.Info("dumped desynced state", "prefix", prefix)
+}
+
 func (g *Game) Close(ctx context.Context) error {
 	return g.sess.Close(ctx)
 }
 
+// CloseReason returns why the server closed the session, such as a wrong
+// password, or an empty string if it gave no reason or is still connected.
+func (g *Game) CloseReason() string {
+	return g.sess.CloseReason()
+}
+
 func (g *Game) Layout(int, int) (int, int) {
 	return state.ScreenWidth, state.ScreenHeight
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
-	for _, bullet := range g.state.Bullets {
-		var m ebiten.GeoM
-		m.Scale(2, 2)
-		m.Translate(bullet.Trans.X, bullet.Trans.Y)
-		screen.DrawImage(assets.Bullet, &ebiten.DrawImageOptions{GeoM: m})
-	}
-
-	for _, asteroid := range g.state.Asteroids {
-		var m ebiten.GeoM
-		bounds := assets.Rock.Bounds()
-		m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
-		m.Rotate(asteroid.Rotation)
-		m.Scale(
-			state.AsteroidWidth/float64(bounds.Dx()),
-			state.AsteroidHeight/float64(bounds.Dy()),
-		)
-		m.Translate(asteroid.Trans.X, asteroid.Trans.Y)
-		screen.DrawImage(assets.Rock, &ebiten.DrawImageOptions{GeoM: m})
-	}
-
-	for _, player := range g.state.Players {
-		var m ebiten.GeoM
-		bounds := assets.Player.Bounds()
-		m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
-		m.Rotate(player.Rotation)
-		m.Scale(
-			state.PlayerWidth/float64(bounds.Dx()),
-			state.PlayerHeight/float64(bounds.Dy()),
-		)
-		m.Translate(player.Trans.X, player.Trans.Y)
-		screen.DrawImage(assets.Player, &ebiten.DrawImageOptions{
-			GeoM: m,
-		})
-
-		op := &text.DrawOptions{}
-		op.GeoM.Translate(player.Trans.X-state.PlayerWidth, player.Trans.Y-state.PlayerHeight)
-		text.Draw(screen, fmt.Sprintf("%d", player.ID), &text.GoTextFace{
-			Source: assets.MPlus1pRegular,
-			Size:   50,
-		}, op)
-	}
-
-	text.Draw(
-		screen,
-		fmt.Sprintf("Total Score: %d", g.state.TotalScore),
-		&text.GoTextFace{Source: assets.MPlus1pRegular, Size: 60},
-		&text.DrawOptions{},
-	)
+	g.welcomeLock.Lock()
+	names := g.names
+	g.welcomeLock.Unlock()
+
+	render.State(screen, g.state, names, g.camera)
+	if !g.overview {
+		render.Indicators(screen, g.state, names, g.camera, g.followed())
+	}
+
+	if g.showTop {
+		g.topLock.Lock()
+		render.Leaderboard(screen, g.top, g.topLoaded)
+		g.topLock.Unlock()
+	}
+
+	g.drawChat(screen)
+
+	if reason := g.sess.CloseReason(); len(reason) > 0 {
+		render.Message(screen, fmt.Sprintf("Disconnected: %s. Press Esc to quit.", reason))
+	}
+}
+
+const (
+	chatLogSize  = 8
+	chatDuration = 10 * time.Second
+	chatFade     = 2 * time.Second
+)
+
+// drawChat draws the chat log, fading out lines as they grow old unless a line
+// is being typed.
+func (g *Game) drawChat(screen *ebiten.Image) {
+	g.chatLock.Lock()
+	log := make([]render.ChatLine, len(g.chatLog))
+	for i, entry := range g.chatLog {
+		alpha := float32(1)
+		if !g.typing {
+			left := chatDuration - time.Since(entry.t)
+			alpha = float32(min(1, max(0, left.Seconds()/chatFade.Seconds())))
+		}
+		log[i] = render.ChatLine{Chat: entry.chat, Alpha: alpha}
+	}
+	g.chatLock.Unlock()
+
+	render.Chat(screen, log, string(g.draft), g.typing)
 }
 
 func (g *Game) Update() error {
+	// Stay around to tell why the server let us go, if it said.
 	if g.sess.Closed() {
-		return ebiten.Termination
+		if len(g.sess.CloseReason()) == 0 || inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			return ebiten.Termination
+		}
+		return nil
 	}
 
-	input := state.Input{
-		Left:  ebiten.IsKeyPressed(ebiten.KeyA),
-		Down:  ebiten.IsKeyPressed(ebiten.KeyS),
-		Up:    ebiten.IsKeyPressed(ebiten.KeyW),
-		Right: ebiten.IsKeyPressed(ebiten.KeyD),
-		Space: ebiten.IsKeyPressed(ebiten.KeySpace),
+	if g.typing {
+		g.updateChat()
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.typing = true
+		g.draft = g.draft[:0]
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		g.toggleLeaderboard()
 	}
 
-	var inputsBuf bytes.Buffer
-	g.inputBufferLock.Lock()
-	g.inputBuffer.Append(input)
-	g.inputBuffer.Encode(&inputsBuf)
-	g.inputBufferLock.Unlock()
-	_ = g.sess.TrySend(inputsBuf.Bytes())
+	if !g.spectate {
+		g.sendInput()
+	} else if !g.typing {
+		switch {
+		case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+			g.followNext()
+			g.overview = false
+		case inpututil.IsKeyJustPressed(ebiten.KeyF):
+			g.overview = !g.overview
+			g.camera = render.Camera{}
+		}
+	}
 
 	g.snapshotLock.Lock()
 	if !g.nextSnapshot.t.IsZero() {
@@ -222,5 +501,171 @@ func (g *Game) Update() error {
 	}
 	g.snapshotLock.Unlock()
 
+	g.updateCamera()
+
 	return nil
 }
+
+// sendInput sends the keys held down this frame along with the inputs the
+// server has yet to acknowledge. The ship is left alone while typing.
+func (g *Game) sendInput() {
+	var input state.Input
+	if !g.typing {
+		input = state.Input{
+			Left:  ebiten.IsKeyPressed(ebiten.KeyA),
+			Down:  ebiten.IsKeyPressed(ebiten.KeyS),
+			Up:    ebiten.IsKeyPressed(ebiten.KeyW),
+			Right: ebiten.IsKeyPressed(ebiten.KeyD),
+			Space: ebiten.IsKeyPressed(ebiten.KeySpace),
+		}
+	}
+
+	var inputsBuf bytes.Buffer
+	_ = binary.Write(&inputsBuf, binary.BigEndian, uint16(0) /* type = inputs */)
+	g.inputBufferLock.Lock()
+	g.inputBuffer.Append(input)
+	g.inputBuffer.Encode(&inputsBuf)
+	g.inputBufferLock.Unlock()
+	_ = g.sess.TrySend(inputsBuf.Bytes())
+}
+
+// topSize is how many of the best scores the leaderboard shows.
+const topSize = 10
+
+// toggleLeaderboard shows or hides the leaderboard, asking the server for the
+// latest one as it is shown.
+func (g *Game) toggleLeaderboard() {
+	g.showTop = !g.showTop
+	if !g.showTop {
+		return
+	}
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(2) /* type = leaderboard query */)
+	_ = buf.WriteByte(topSize)
+	_ = g.sess.TrySend(buf.Bytes())
+}
+
+// queryNames asks the server for the latest names of the players.
+func (g *Game) queryNames() {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(3) /* type = names query */)
+	_ = g.sess.TrySend(buf.Bytes())
+}
+
+// updateChat types the characters input this frame into the draft, sending it
+// on Enter and dropping it on Escape.
+func (g *Game) updateChat() {
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter), inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter):
+		g.typing = false
+		text := state.CleanChat(string(g.draft))
+		if len(text) == 0 {
+			return
+		}
+		var buf bytes.Buffer
+		_ = binary.Write(&buf, binary.BigEndian, uint16(1) /* type = chat */)
+		_, _ = buf.WriteString(text)
+		_ = g.sess.TrySend(buf.Bytes())
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		g.typing = false
+	case repeated(ebiten.KeyBackspace):
+		if len(g.draft) > 0 {
+			g.draft = g.draft[:len(g.draft)-1]
+		}
+	default:
+		g.draft = ebiten.AppendInputChars(g.draft)
+		if len(string(g.draft)) > state.MaxChatLen {
+			g.draft = []rune(state.CleanChat(string(g.draft)))
+		}
+	}
+}
+
+// repeated tells whether key was just pressed or has been held down long
+// enough to repeat.
+func repeated(key ebiten.Key) bool {
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || d >= keyRepeatDelay && (d-keyRepeatDelay)%keyRepeatInterval == 0
+}
+
+// keyRepeatDelay and keyRepeatInterval are in ticks.
+const (
+	keyRepeatDelay    = 30
+	keyRepeatInterval = 3
+)
+
+// cameraSmoothing is how quickly the camera catches up with the player; see
+// render.Camera.Follow.
+const cameraSmoothing = 150 * time.Millisecond
+
+// updateCamera follows the local player around, staying where they died until
+// they respawn. Spectators follow whoever they picked instead, moving on to
+// the next player once they leave, or see the whole world in the overview.
+func (g *Game) updateCamera() {
+	if g.spectate {
+		if g.overview {
+			g.camera = render.WholeWorld(g.state.World)
+			return
+		}
+		if !slices.ContainsFunc(g.state.Players, func(p state.Player) bool { return p.ID == g.followID }) {
+			g.followNext()
+		}
+	}
+	g.camera = follow(g.camera, g.state, g.followed())
+}
+
+// followed returns the ID of the player the camera follows.
+func (g *Game) followed() uint16 {
+	if g.spectate {
+		return g.followID
+	}
+	g.welcomeLock.Lock()
+	defer g.welcomeLock.Unlock()
+	return g.playerID
+}
+
+// followNext has a spectator follow the player with the next ID, or the first
+// one.
+func (g *Game) followNext() {
+	id, ok := nextPlayerID(g.state, g.followID)
+	if !ok {
+		return
+	}
+	if id != g.followID {
+		g.camera = render.Camera{}
+	}
+	g.followID = id
+}
+
+// follow returns cam moved a frame towards the player with id in s, or left
+// alone while they are dead or missing.
+func follow(cam render.Camera, s state.State, id uint16) render.Camera {
+	for _, player := range s.Players {
+		if player.ID != id || player.Dead {
+			continue
+		}
+		if cam == (render.Camera{}) {
+			return render.Camera{Center: player.Trans}
+		}
+		dt := time.Second / time.Duration(ebiten.TPS())
+		return cam.Follow(s.World, player.Trans, dt, cameraSmoothing)
+	}
+	return cam
+}
+
+// nextPlayerID returns the ID of the player in s after the one with id, going
+// around to the first, or false if there are no players.
+func nextPlayerID(s state.State, id uint16) (uint16, bool) {
+	ids := make([]uint16, 0, len(s.Players))
+	for _, player := range s.Players {
+		ids = append(ids, player.ID)
+	}
+	if len(ids) == 0 {
+		return 0, false
+	}
+	slices.Sort(ids)
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		i++
+	}
+	return ids[i%len(ids)], true
+}
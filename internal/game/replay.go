@@ -0,0 +1,110 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"multiplayer/internal/render"
+	"multiplayer/internal/replay"
+	"multiplayer/internal/state"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// replaySeek is how far the arrow keys move a replay.
+const replaySeek = 5 * time.Second
+
+// Replay plays a recorded match back, drawn the way the game draws it. The
+// camera follows one of the players, whom Tab switches between.
+type Replay struct {
+	reader   *replay.Reader
+	playback *replay.Playback
+	state    state.State
+	camera   render.Camera
+	playerID uint16
+}
+
+// LoadReplay opens the replay file at path, which stays open until Close.
+func LoadReplay(path string) (*Replay, error) {
+	rr, err := replay.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	playback, err := replay.NewPlayback(rr)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("replay %q: %w", path, err), rr.Close())
+	}
+	r := &Replay{
+		reader:   rr,
+		playback: playback,
+		state:    state.State{},
+		camera:   render.Camera{},
+		playerID: 0,
+	}
+	r.state = r.playback.State()
+	r.followNext()
+	return r, nil
+}
+
+func (r *Replay) Close() error {
+	return r.reader.Close()
+}
+
+func (r *Replay) Layout(int, int) (int, int) {
+	return state.ScreenWidth, state.ScreenHeight
+}
+
+func (r *Replay) Draw(screen *ebiten.Image) {
+	names := r.playback.Names()
+	render.State(screen, r.state, names, r.camera)
+	render.Indicators(screen, r.state, names, r.camera, r.playerID)
+
+	status := "playing"
+	if r.playback.Paused() {
+		status = "paused"
+	}
+	ebitenutil.DebugPrint(screen, fmt.Sprintf(
+		"%s / %s  %s at %gx  following %s\n"+
+			"space: pause  left/right: seek  up/down: speed  home: restart  tab: follow next",
+		r.playback.Position().Truncate(time.Second), r.playback.Length().Truncate(time.Second),
+		status, r.playback.Speed(), names.Of(r.playerID)))
+}
+
+func (r *Replay) Update() error {
+	p := r.playback
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeySpace):
+		p.SetPaused(!p.Paused())
+	case inpututil.IsKeyJustPressed(ebiten.KeyLeft):
+		p.Seek(-replaySeek)
+	case inpututil.IsKeyJustPressed(ebiten.KeyRight):
+		p.Seek(replaySeek)
+	case inpututil.IsKeyJustPressed(ebiten.KeyUp):
+		p.SetSpeed(2 * p.Speed())
+	case inpututil.IsKeyJustPressed(ebiten.KeyDown):
+		p.SetSpeed(p.Speed() / 2)
+	case inpututil.IsKeyJustPressed(ebiten.KeyHome):
+		p.Seek(-p.Position())
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		r.followNext()
+	}
+
+	p.Advance(time.Second / time.Duration(ebiten.TPS()))
+	r.state = p.State()
+	r.camera = follow(r.camera, r.state, r.playerID)
+	return nil
+}
+
+// followNext has the camera follow the player with the next ID, or the
+// first one.
+func (r *Replay) followNext() {
+	id, ok := nextPlayerID(r.state, r.playerID)
+	if !ok {
+		r.camera = render.WholeWorld(r.state.World)
+		return
+	}
+	r.playerID = id
+	r.camera = render.Camera{}
+}
@@ -0,0 +1,213 @@
+// Package leaderboard keeps the best score of every nickname along with the
+// results of the latest matches in a JSON file, which is replaced as a whole
+// every time so that a crash never leaves it half written.
+package leaderboard
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MaxMatches is how many of the latest matches are kept.
+const MaxMatches = 100
+
+// Entry is the best score of a nickname.
+type Entry struct {
+	Name  string    `json:"name"`
+	Score uint32    `json:"score"`
+	Time  time.Time `json:"time"` // when the score was reached
+}
+
+// Result is how a player did in a match.
+type Result struct {
+	Name   string `json:"name"`
+	Score  uint32 `json:"score"`
+	Kills  uint16 `json:"kills"`
+	Deaths uint16 `json:"deaths"`
+}
+
+// Match is the outcome of a game played until it was over.
+type Match struct {
+	Room       string    `json:"room"`
+	Mode       string    `json:"mode"`
+	Ended      time.Time `json:"ended"`
+	Wave       uint16    `json:"wave"`
+	TotalScore uint32    `json:"total_score"`
+	Players    []Result  `json:"players"`
+}
+
+// file is the layout of a leaderboard file.
+type file struct {
+	Best    []Entry `json:"best"`
+	Matches []Match `json:"matches"`
+}
+
+// Board is a leaderboard kept in a file. It is safe for concurrent use.
+type Board struct {
+	path    string
+	best    map[string]Entry
+	matches []Match
+	lock    sync.Mutex
+}
+
+// Open reads the leaderboard at path, starting an empty one if there is no
+// such file yet.
+func Open(path string) (*Board, error) {
+	b := &Board{
+		path:    path,
+		best:    map[string]Entry{},
+		matches: nil,
+		lock:    sync.Mutex{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("leaderboard %s: %w", path, err)
+	}
+	for _, entry := range f.Best {
+		b.best[entry.Name] = entry
+	}
+	b.matches = f.Matches
+	return b, nil
+}
+
+// Record adds m to the latest matches and raises the best score of every
+// player in it with a name, then saves the leaderboard.
+func (b *Board) Record(m Match) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, result := range m.Players {
+		if len(result.Name) == 0 {
+			continue
+		}
+		if best, ok := b.best[result.Name]; ok && best.Score >= result.Score {
+			continue
+		}
+		b.best[result.Name] = Entry{Name: result.Name, Score: result.Score, Time: m.Ended}
+	}
+	b.matches = append(b.matches, m)
+	if len(b.matches) > MaxMatches {
+		b.matches = slices.Delete(b.matches, 0, len(b.matches)-MaxMatches)
+	}
+	return b.save()
+}
+
+// Top returns the n best scores, the highest first. Ties go to whoever got
+// there first.
+func (b *Board) Top(n int) []Entry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return top(b.best, n)
+}
+
+func top(best map[string]Entry, n int) []Entry {
+	entries := make([]Entry, 0, len(best))
+	for _, entry := range best {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), a.Time.Compare(b.Time), cmp.Compare(a.Name, b.Name))
+	})
+	return entries[:min(n, len(entries))]
+}
+
+// Matches returns the latest matches, the oldest first.
+func (b *Board) Matches() []Match {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return slices.Clone(b.matches)
+}
+
+// save writes the leaderboard to a temporary file next to its file, and then
+// renames it over the file.
+func (b *Board) save() error {
+	data, err := json.MarshalIndent(file{
+		Best:    top(b.best, len(b.best)),
+		Matches: b.matches,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), b.path)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	return nil
+}
+
+// Encode writes entries as their number followed by the length-prefixed name,
+// score and Unix time of every one of them, for sending them to clients.
+func Encode(buf *bytes.Buffer, entries []Entry) {
+	_ = buf.WriteByte(byte(min(len(entries), 255)))
+	for _, entry := range entries[:min(len(entries), 255)] {
+		name := entry.Name
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		_ = buf.WriteByte(byte(len(name)))
+		_, _ = buf.WriteString(name)
+		_ = binary.Write(buf, binary.BigEndian, entry.Score)
+		_ = binary.Write(buf, binary.BigEndian, entry.Time.Unix())
+	}
+}
+
+// Decode reads entries encoded by Encode.
+func Decode(r *bytes.Reader) ([]Entry, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("leaderboard: %w", err)
+	}
+	entries := make([]Entry, count)
+	for i := range entries {
+		var n byte
+		n, err = r.ReadByte()
+		name := make([]byte, n)
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, name)
+		}
+		var score uint32
+		var unix int64
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &score)
+		}
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &unix)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("leaderboard: entry %d: %w", i, err)
+		}
+		entries[i] = Entry{Name: string(name), Score: score, Time: time.Unix(unix, 0)}
+	}
+	return entries, nil
+}
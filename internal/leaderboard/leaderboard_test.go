@@ -0,0 +1,100 @@
+package leaderboard_test
+
+import (
+	"bytes"
+	"multiplayer/internal/leaderboard"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func match(ended time.Time, results ...leaderboard.Result) leaderboard.Match {
+	return leaderboard.Match{
+		Room:       "1",
+		Mode:       "coop",
+		Ended:      ended,
+		Wave:       3,
+		TotalScore: 0,
+		Players:    results,
+	}
+}
+
+func TestBoard_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaderboard.json")
+	b, err := leaderboard.Open(path)
+	assert.NoError(t, err)
+	assert.Empty(t, b.Top(10), "a missing file is an empty leaderboard")
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, b.Record(match(t0,
+		leaderboard.Result{Name: "Ripley", Score: 500},
+		leaderboard.Result{Name: "Hicks", Score: 300},
+		leaderboard.Result{Name: "", Score: 900},
+	)))
+	assert.NoError(t, b.Record(match(t0.Add(time.Hour),
+		leaderboard.Result{Name: "Ripley", Score: 200},
+		leaderboard.Result{Name: "Hicks", Score: 500},
+	)))
+
+	want := []leaderboard.Entry{
+		{Name: "Ripley", Score: 500, Time: t0},
+		{Name: "Hicks", Score: 500, Time: t0.Add(time.Hour)},
+	}
+	assert.Equal(t, want, b.Top(10), "players without a name are left out and ties go to the first")
+	assert.Equal(t, want[:1], b.Top(1))
+	assert.Len(t, b.Matches(), 2)
+
+	reopened, err := leaderboard.Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, len(want), len(reopened.Top(10)))
+	for i, entry := range reopened.Top(10) {
+		assert.Equal(t, want[i].Name, entry.Name)
+		assert.Equal(t, want[i].Score, entry.Score)
+		assert.True(t, want[i].Time.Equal(entry.Time))
+	}
+	assert.Len(t, reopened.Matches(), 2)
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "no temporary files are left behind")
+}
+
+func TestBoard_Record_maxMatches(t *testing.T) {
+	b, err := leaderboard.Open(filepath.Join(t.TempDir(), "leaderboard.json"))
+	assert.NoError(t, err)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range leaderboard.MaxMatches + 5 {
+		assert.NoError(t, b.Record(match(t0.Add(time.Duration(i)*time.Minute))))
+	}
+	matches := b.Matches()
+	assert.Len(t, matches, leaderboard.MaxMatches)
+	assert.Equal(t, t0.Add(5*time.Minute), matches[0].Ended, "the oldest matches are dropped")
+}
+
+func TestOpen_invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaderboard.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err := leaderboard.Open(path)
+	assert.Error(t, err)
+}
+
+func TestEncode(t *testing.T) {
+	entries := []leaderboard.Entry{
+		{Name: "Ripley", Score: 500, Time: time.Unix(1700000000, 0)},
+		{Name: "Hicks", Score: 300, Time: time.Unix(1700000100, 0)},
+	}
+	var buf bytes.Buffer
+	leaderboard.Encode(&buf, entries)
+	decoded, err := leaderboard.Decode(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, entries, decoded)
+
+	data := buf.Bytes()
+	for n := 0; n < len(data); n++ {
+		_, err = leaderboard.Decode(bytes.NewReader(data[:n]))
+		assert.Error(t, err, "decoding %d of %d bytes", n, len(data))
+	}
+}
@@ -0,0 +1,172 @@
+package mcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrNotAllowed = errors.New("address is not allowed")
+
+// access decides which remote addresses may reach a listener. Banned prefixes
+// are always refused and, once any prefix is allowed, so is every address
+// outside of the allowed ones. An access is never modified once stored in a
+// listener, so that readLoop can check it without locking.
+type access struct {
+	banned  []netip.Prefix
+	allowed []netip.Prefix
+}
+
+// accessFile is how an access is kept on disk, such as:
+//
+//	{
+//		"banned": ["203.0.113.7", "198.51.100.0/24"],
+//		"allowed": []
+//	}
+type accessFile struct {
+	Banned  []string `json:"banned"`
+	Allowed []string `json:"allowed"`
+}
+
+// check returns why addr may not reach the listener, or nil if it may.
+func (a *access) check(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if contains(a.banned, addr) {
+		return ErrBanned
+	}
+	if len(a.allowed) > 0 && !contains(a.allowed, addr) {
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *access) clone() *access {
+	return &access{
+		banned:  slices.Clone(a.banned),
+		allowed: slices.Clone(a.allowed),
+	}
+}
+
+// parsePrefix parses an IP address, standing for itself alone, or a CIDR
+// prefix such as 10.0.0.0/8.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.WithZone("").Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// formatPrefixes formats prefixes in order, leaving single addresses without
+// their prefix length.
+func formatPrefixes(prefixes []netip.Prefix) []string {
+	formatted := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix.IsSingleIP() {
+			formatted = append(formatted, prefix.Addr().String())
+		} else {
+			formatted = append(formatted, prefix.String())
+		}
+	}
+	slices.Sort(formatted)
+	return formatted
+}
+
+// loadAccess reads the access at path, which is empty if there is no such
+// file yet.
+func loadAccess(path string) (*access, error) {
+	a := &access{banned: nil, allowed: nil}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f accessFile
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("access %s: %w", path, err)
+	}
+	for _, s := range f.Banned {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("access %s: banned: %w", path, err)
+		}
+		a.banned = append(a.banned, prefix)
+	}
+	for _, s := range f.Allowed {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("access %s: allowed: %w", path, err)
+		}
+		a.allowed = append(a.allowed, prefix)
+	}
+	return a, nil
+}
+
+// save writes a to a temporary file next to path, and then renames it over
+// path.
+func (a *access) save(path string) error {
+	data, err := json.MarshalIndent(accessFile{
+		Banned:  formatPrefixes(a.banned),
+		Allowed: formatPrefixes(a.allowed),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	return nil
+}
+
+// addrOf returns the IP address of addr, or the zero address if it has none.
+func addrOf(addr net.Addr) netip.Addr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp.AddrPort().Addr().Unmap()
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
@@ -3,15 +3,21 @@ package mcp
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
 	"maps"
 	"net"
+	"net/netip"
 	"os"
 	"reflect"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -20,6 +26,9 @@ import (
 var (
 	ErrClosed       = errors.New("use of closed network connection")
 	ErrNegativeSize = errors.New("provision of negative value as size")
+	ErrBanned       = errors.New("address is banned")
+	ErrPassword     = errors.New("wrong password")
+	ErrNoPassword   = errors.New("password required")
 )
 
 const version byte = 1
@@ -27,8 +36,26 @@ const version byte = 1
 const (
 	flagJoin uint16 = 1 << iota
 	flagLeave
+	flagPing
+	flagPong
+	flagChallenge
+	flagResponse
 )
 
+// Listeners with a password challenge remotes asking to join with a random
+// nonce, letting them in once they answer with its HMAC-SHA256 keyed by the
+// password. The challenges of remotes who never answer expire after
+// challengeTimeout, and at most maxChallenges are pending at once.
+const (
+	nonceSize        = 16
+	challengeTimeout = 5 * time.Second
+	maxChallenges    = 1024
+)
+
+// pingInterval is how often listeners ping their sessions to measure the
+// round-trip time.
+const pingInterval = time.Second
+
 // how is this any different from net.PacketConn?
 //
 // 1. broadcast (channels)
@@ -39,11 +66,18 @@ type Listener struct {
 	options
 	local net.Addr
 
-	sessions    map[string]*Session // maps raddr to session
-	sessionCond sync.Cond           // notifies _addition_ of new sessions
+	sessions    map[string]*Session  // maps raddr to session
+	challenges  map[string]challenge // maps raddr to pending challenge, guarded by sessionCond.L
+	sessionCond sync.Cond            // notifies _addition_ of new sessions
 	acceptCh    chan *Session
 
-	conn    net.PacketConn
+	// access is swapped as a whole under accessLock, which also keeps saves
+	// of the access file in order, and loaded by readLoop without locking.
+	access     atomic.Pointer[access]
+	accessLock sync.Mutex
+
+	conn    *net.UDPConn
+	stats   stats
 	die     chan struct{}
 	dieOnce sync.Once
 }
@@ -52,12 +86,86 @@ func (ln *Listener) LocalAddr() net.Addr {
 	return ln.local
 }
 
+// DataSize returns the most data a datagram of ln carries. Larger messages
+// are cut short as they are received.
+func (ln *Listener) DataSize() int {
+	return ln.dataSize
+}
+
+// Stats are running totals of what went through a listener.
+type Stats struct {
+	BytesIn      uint64
+	BytesOut     uint64
+	DatagramsIn  uint64
+	DatagramsOut uint64
+	// InboxDropped and OutboxDropped count the messages dropped because the
+	// receiving end was not keeping up.
+	InboxDropped  uint64
+	OutboxDropped uint64
+	// DecodeFailures counts the datagrams which could not be made sense of.
+	DecodeFailures uint64
+	// JoinRejections counts the joins refused, whether by the listener or
+	// through Session.Refuse.
+	JoinRejections uint64
+	// Denied counts the datagrams dropped as they came from a banned address
+	// or one outside of the allowed ones.
+	Denied uint64
+}
+
+type stats struct {
+	bytesIn        atomic.Uint64
+	bytesOut       atomic.Uint64
+	datagramsIn    atomic.Uint64
+	datagramsOut   atomic.Uint64
+	inboxDropped   atomic.Uint64
+	outboxDropped  atomic.Uint64
+	decodeFailures atomic.Uint64
+	joinRejections atomic.Uint64
+	denied         atomic.Uint64
+}
+
+func (st *stats) wrote(n int) {
+	st.bytesOut.Add(uint64(n))
+	st.datagramsOut.Add(1)
+}
+
+func (ln *Listener) Stats() Stats {
+	return Stats{
+		BytesIn:        ln.stats.bytesIn.Load(),
+		BytesOut:       ln.stats.bytesOut.Load(),
+		DatagramsIn:    ln.stats.datagramsIn.Load(),
+		DatagramsOut:   ln.stats.datagramsOut.Load(),
+		InboxDropped:   ln.stats.inboxDropped.Load(),
+		OutboxDropped:  ln.stats.outboxDropped.Load(),
+		DecodeFailures: ln.stats.decodeFailures.Load(),
+		JoinRejections: ln.stats.joinRejections.Load(),
+		Denied:         ln.stats.denied.Load(),
+	}
+}
+
+// NumSessions returns the number of open sessions.
+func (ln *Listener) NumSessions() int {
+	ln.sessionCond.L.Lock()
+	defer ln.sessionCond.L.Unlock()
+	return len(ln.sessions)
+}
+
 type Option func(opts *options) error
 
 type options struct {
-	dial     bool
-	dataSize int
-	logger   *slog.Logger
+	dial       bool
+	dataSize   int
+	logger     *slog.Logger
+	joinData   []byte
+	password   []byte
+	accessPath string
+}
+
+// challenge is sent to a remote asking to join a listener with a password.
+type challenge struct {
+	nonce    []byte
+	joinData []byte
+	expires  time.Time
 }
 
 func WithDataSize(dataSize int) Option {
@@ -78,6 +186,34 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
+// WithJoinData attaches data to the request to join sent by Dial, which the
+// listener hands over through Session.JoinData, such as to pick a room.
+func WithJoinData(data []byte) Option {
+	return func(opts *options) error {
+		opts.joinData = data
+		return nil
+	}
+}
+
+// WithPassword has a listener only let in remotes who know password, or has
+// Dial prove that it knows it. The password itself never goes over the wire.
+func WithPassword(password string) Option {
+	return func(opts *options) error {
+		opts.password = []byte(password)
+		return nil
+	}
+}
+
+// WithAccessFile has a listener keep its banned and allowed addresses in the
+// JSON file at path, which is read as the listener starts, written whenever
+// they change and read again by Reload.
+func WithAccessFile(path string) Option {
+	return func(opts *options) error {
+		opts.accessPath = path
+		return nil
+	}
+}
+
 func withDial(dial bool) Option {
 	return func(opts *options) error {
 		opts.dial = dial
@@ -87,19 +223,38 @@ func withDial(dial bool) Option {
 
 func Listen(laddr string, opts ...Option) (*Listener, error) {
 	o := options{
-		dial:     false,
-		dataSize: 512 - headerSize, // avoid fragmentation
-		logger:   slog.New(slog.DiscardHandler),
+		dial:       false,
+		dataSize:   1200 - headerSize, // avoid fragmentation, like QUIC does
+		logger:     slog.New(slog.DiscardHandler),
+		joinData:   nil,
+		password:   nil,
+		accessPath: "",
 	}
 	var optErrs []error
 	for _, opt := range opts {
 		optErrs = append(optErrs, opt(&o))
 	}
+	if len(o.joinData) > o.dataSize {
+		optErrs = append(optErrs, fmt.Errorf("join data of %d bytes: larger than data size %d", len(o.joinData), o.dataSize))
+	}
 	if err := errors.Join(optErrs...); err != nil {
 		return nil, err
 	}
 
-	conn, err := net.ListenPacket("udp", laddr)
+	a := &access{banned: nil, allowed: nil}
+	if len(o.accessPath) > 0 {
+		var err error
+		a, err = loadAccess(o.accessPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -109,14 +264,20 @@ func Listen(laddr string, opts ...Option) (*Listener, error) {
 		options:     o,
 		local:       conn.LocalAddr(),
 		sessions:    map[string]*Session{},
+		challenges:  map[string]challenge{},
 		sessionCond: sync.Cond{L: &sync.Mutex{}},
 		acceptCh:    make(chan *Session),
+		access:      atomic.Pointer[access]{},
+		accessLock:  sync.Mutex{},
 		conn:        conn,
+		stats:       stats{},
 		die:         make(chan struct{}),
 		dieOnce:     sync.Once{},
 	}
+	ln.access.Store(a)
 	go ln.readLoop()
 	go ln.writeLoop()
+	go ln.pingLoop()
 	return ln, nil
 }
 
@@ -135,17 +296,18 @@ func Dial(ctx context.Context, raddr string, opts ...Option) (*Session, error) {
 	datagram := Datagram{
 		Version: version,
 		Flags:   flagJoin,
-		Data:    nil,
+		Data:    ln.joinData,
 	}
 	b, err := datagram.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = writeToWithContext(ctx, ln.logger, ln.conn, b, remote)
+	n, err := writeToWithContext(ctx, ln.logger, ln.conn, b, remote)
 	if err != nil {
 		return nil, err
 	}
+	ln.stats.wrote(n)
 
 	// TODO: retry if not acknowledged
 
@@ -216,23 +378,33 @@ func (ln *Listener) Accept(ctx context.Context) (*Session, error) {
 }
 
 func (ln *Listener) Broadcast(ctx context.Context, data []byte) error {
+	ln.sessionCond.L.Lock()
+	sessions := slices.Collect(maps.Values(ln.sessions))
+	ln.sessionCond.L.Unlock()
+	return ln.Multicast(ctx, sessions, data)
+}
+
+// Multicast sends data to every one of sessions, which must belong to ln,
+// in whichever order they are ready to take it.
+func (ln *Listener) Multicast(ctx context.Context, sessions []*Session, data []byte) error {
 	const (
 		caseDie = 0
 		caseCtx = 1
 	)
 
+	// sending to the outbox of a closed session would panic
+	sessions = slices.DeleteFunc(slices.Clone(sessions), (*Session).Closed)
+
 	dataVal := reflect.ValueOf(data)
-	ln.sessionCond.L.Lock()
-	numSessions := len(ln.sessions)
+	numSessions := len(sessions)
 	cases := make([]reflect.SelectCase, 2, 2+numSessions)
-	for _, sess := range ln.sessions {
+	for _, sess := range sessions {
 		cases = append(cases, reflect.SelectCase{
 			Dir:  reflect.SelectSend,
 			Chan: reflect.ValueOf(sess.outbox),
 			Send: dataVal,
 		})
 	}
-	ln.sessionCond.L.Unlock()
 	cases[caseDie] = reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(ln.die),
@@ -305,7 +477,7 @@ WRITER:
 			ln.logger.Warn("failed to marshal datagram", "error", err)
 			continue
 		}
-		_, err = ln.conn.WriteTo(marshaledDatagram, sessions[chosenIdx].remote)
+		n, err := ln.conn.WriteTo(marshaledDatagram, sessions[chosenIdx].remote)
 		if errors.Is(err, net.ErrClosed) {
 			break
 		}
@@ -315,20 +487,213 @@ WRITER:
 				"error", err)
 			continue
 		}
+		ln.stats.wrote(n)
+	}
+}
+
+// pingLoop pings every session each pingInterval. The pongs are handled by
+// ln.handleDatagram, which updates the round-trip time of the session.
+func (ln *Listener) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ln.die:
+			return
+		case <-ticker.C:
+		}
+
+		ln.sessionCond.L.Lock()
+		sessions := slices.Collect(maps.Values(ln.sessions))
+		ln.sessionCond.L.Unlock()
+
+		data := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+		for _, sess := range sessions {
+			err := ln.writeControl(flagPing, data, sess.remote)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				ln.logger.Warn("failed to ping session",
+					"raddr", sess.remote,
+					"error", err)
+			}
+		}
+	}
+}
+
+// writeControl writes a datagram with flags to remote right away, bypassing
+// the outboxes of the sessions.
+func (ln *Listener) writeControl(flags uint16, data []byte, remote net.Addr) error {
+	datagram := Datagram{
+		Version: version,
+		Flags:   flags,
+		Data:    data,
+	}
+	b, err := datagram.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	n, err := ln.conn.WriteTo(b, remote)
+	if err != nil {
+		return err
+	}
+	ln.stats.wrote(n)
+	return nil
+}
+
+// Ban refuses any further datagram from addr, an IP address or a CIDR prefix
+// such as 10.0.0.0/8, and closes the sessions of the remote addresses in it.
+func (ln *Listener) Ban(ctx context.Context, addr string) error {
+	prefix, err := parsePrefix(addr)
+	if err != nil {
+		return fmt.Errorf("ban %q: %w", addr, err)
+	}
+	return ln.updateAccess(ctx, func(a *access) {
+		if !slices.Contains(a.banned, prefix) {
+			a.banned = append(a.banned, prefix)
+		}
+	})
+}
+
+// Unban lets addr, as given to Ban, in again.
+func (ln *Listener) Unban(ctx context.Context, addr string) error {
+	prefix, err := parsePrefix(addr)
+	if err != nil {
+		return fmt.Errorf("unban %q: %w", addr, err)
+	}
+	return ln.updateAccess(ctx, func(a *access) {
+		a.banned = slices.DeleteFunc(a.banned, func(p netip.Prefix) bool { return p == prefix })
+	})
+}
+
+// Banned returns the banned addresses and prefixes in order.
+func (ln *Listener) Banned() []string {
+	return formatPrefixes(ln.access.Load().banned)
+}
+
+// Allow lets in addr, an IP address or a CIDR prefix. Once anything is
+// allowed, only the allowed addresses which are not banned get in, and the
+// sessions of every other remote address are closed.
+func (ln *Listener) Allow(ctx context.Context, addr string) error {
+	prefix, err := parsePrefix(addr)
+	if err != nil {
+		return fmt.Errorf("allow %q: %w", addr, err)
+	}
+	return ln.updateAccess(ctx, func(a *access) {
+		if !slices.Contains(a.allowed, prefix) {
+			a.allowed = append(a.allowed, prefix)
+		}
+	})
+}
+
+// Disallow takes addr, as given to Allow, off the allowed addresses. Once
+// nothing is allowed anymore, everyone who is not banned gets in.
+func (ln *Listener) Disallow(ctx context.Context, addr string) error {
+	prefix, err := parsePrefix(addr)
+	if err != nil {
+		return fmt.Errorf("disallow %q: %w", addr, err)
+	}
+	return ln.updateAccess(ctx, func(a *access) {
+		a.allowed = slices.DeleteFunc(a.allowed, func(p netip.Prefix) bool { return p == prefix })
+	})
+}
+
+// Allowed returns the allowed addresses and prefixes in order.
+func (ln *Listener) Allowed() []string {
+	return formatPrefixes(ln.access.Load().allowed)
+}
+
+// Reload reads the access file given to WithAccessFile again, such as after
+// editing it by hand, and closes the sessions no longer let in.
+func (ln *Listener) Reload(ctx context.Context) error {
+	if len(ln.accessPath) == 0 {
+		return errors.New("reload: no access file")
+	}
+	ln.accessLock.Lock()
+	a, err := loadAccess(ln.accessPath)
+	if err != nil {
+		ln.accessLock.Unlock()
+		return fmt.Errorf("reload: %w", err)
+	}
+	ln.access.Store(a)
+	ln.accessLock.Unlock()
+	return ln.enforceAccess(ctx)
+}
+
+// updateAccess applies update to a copy of the access of ln, saves it to the
+// access file if there is one and then puts it in place, closing the sessions
+// no longer let in.
+func (ln *Listener) updateAccess(ctx context.Context, update func(a *access)) error {
+	ln.accessLock.Lock()
+	a := ln.access.Load().clone()
+	update(a)
+	if len(ln.accessPath) > 0 {
+		err := a.save(ln.accessPath)
+		if err != nil {
+			ln.accessLock.Unlock()
+			return fmt.Errorf("save access: %w", err)
+		}
+	}
+	ln.access.Store(a)
+	ln.accessLock.Unlock()
+	return ln.enforceAccess(ctx)
+}
+
+// enforceAccess closes the sessions of the remote addresses which are not let
+// in anymore, telling them why.
+func (ln *Listener) enforceAccess(ctx context.Context) error {
+	a := ln.access.Load()
+	type refusal struct {
+		sess   *Session
+		reason error
+	}
+	var refusals []refusal
+	ln.sessionCond.L.Lock()
+	for _, sess := range ln.sessions {
+		if reason := a.check(addrOf(sess.remote)); reason != nil {
+			refusals = append(refusals, refusal{sess: sess, reason: reason})
+		}
+	}
+	ln.sessionCond.L.Unlock()
+
+	var errs []error
+	for _, r := range refusals {
+		err := r.sess.CloseWithReason(ctx, r.reason.Error())
+		if err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, fmt.Errorf("close session %q: %w", r.sess.remote, err))
+		}
 	}
+	return errors.Join(errs...)
 }
 
 func (ln *Listener) readLoop() {
 	buf := make([]byte, headerSize+ln.dataSize)
 	for {
-		n, remote, readErr := ln.conn.ReadFrom(buf)
+		n, addrPort, readErr := ln.conn.ReadFromUDPAddrPort(buf)
 		if errors.Is(readErr, net.ErrClosed) {
 			return
 		}
 
+		if n > 0 {
+			ln.stats.bytesIn.Add(uint64(n))
+			ln.stats.datagramsIn.Add(1)
+		}
+
+		// Drop whatever comes from addresses which are not let in before
+		// spending anything on it, not even a reply: their source may well be
+		// spoofed.
+		if ln.access.Load().check(addrPort.Addr()) != nil {
+			ln.stats.denied.Add(1)
+			continue
+		}
+		remote := net.UDPAddrFromAddrPort(addrPort)
+
 		var datagram Datagram
 		err := datagram.UnmarshalBinary(buf[:n])
 		if err != nil {
+			ln.stats.decodeFailures.Add(1)
 			ln.logger.Warn("failed to unmarshal datagram", "error", err)
 			continue
 		}
@@ -348,6 +713,7 @@ func (ln *Listener) readLoop() {
 
 func (ln *Listener) handleDatagram(ctx context.Context, remote net.Addr, datagram Datagram) error {
 	if datagram.Version != version {
+		ln.stats.decodeFailures.Add(1)
 		return fmt.Errorf("version %d: version is not supported", datagram.Version)
 	}
 	if datagram.Flags&flagJoin != 0 && datagram.Flags&flagLeave != 0 {
@@ -358,17 +724,47 @@ func (ln *Listener) handleDatagram(ctx context.Context, remote net.Addr, datagra
 	case datagram.Flags&flagJoin != 0:
 		// TODO: acknowledge join
 
-		sess := newSession(false, ln.local, remote, ln)
 		ln.sessionCond.L.Lock()
 		if _, exists := ln.sessions[remote.String()]; exists {
 			ln.sessionCond.L.Unlock()
 			return fmt.Errorf("session %q: already exists", remote)
 		}
-		ln.sessions[remote.String()] = sess
+		if len(ln.password) > 0 && !ln.dial {
+			nonce, err := ln.challenge(remote, datagram.Data)
+			ln.sessionCond.L.Unlock()
+			if err != nil {
+				return fmt.Errorf("challenge %q: %w", remote, err)
+			}
+			return ln.writeControl(flagChallenge, nonce, remote)
+		}
 		ln.sessionCond.L.Unlock()
-		ln.sessionCond.Broadcast()
+		ln.admit(remote, datagram.Data)
 
-		ln.acceptCh <- sess
+	case datagram.Flags&flagChallenge != 0:
+		if !ln.dial {
+			return fmt.Errorf("challenge %q: not dialing", remote)
+		}
+		var mac []byte
+		if len(ln.password) > 0 {
+			mac = respond(ln.password, datagram.Data)
+		}
+		return ln.writeControl(flagResponse, mac, remote)
+
+	case datagram.Flags&flagResponse != 0:
+		ln.sessionCond.L.Lock()
+		c, ok := ln.challenges[remote.String()]
+		delete(ln.challenges, remote.String())
+		ln.sessionCond.L.Unlock()
+		if !ok || time.Now().After(c.expires) {
+			return fmt.Errorf("response %q: no pending challenge", remote)
+		}
+		if len(datagram.Data) == 0 {
+			return ln.reject(remote, ErrNoPassword)
+		}
+		if !hmac.Equal(datagram.Data, respond(ln.password, c.nonce)) {
+			return ln.reject(remote, ErrPassword)
+		}
+		ln.admit(remote, c.joinData)
 
 	case datagram.Flags&flagLeave != 0:
 		ln.sessionCond.L.Lock()
@@ -377,6 +773,7 @@ func (ln *Listener) handleDatagram(ctx context.Context, remote net.Addr, datagra
 			return fmt.Errorf("close session %q: not found", remote)
 		}
 		sess := ln.sessions[remote.String()]
+		sess.reason.Store(string(datagram.Data))
 		var err error
 		sess.dieOnce.Do(func() {
 			err = sess.partialUncheckedClose(ctx)
@@ -388,6 +785,29 @@ func (ln *Listener) handleDatagram(ctx context.Context, remote net.Addr, datagra
 		delete(ln.sessions, remote.String())
 		ln.sessionCond.L.Unlock()
 
+	case datagram.Flags&flagPing != 0:
+		// Only answer sessions, or anyone could bounce pongs off ln.
+		ln.sessionCond.L.Lock()
+		_, exists := ln.sessions[remote.String()]
+		ln.sessionCond.L.Unlock()
+		if !exists {
+			return fmt.Errorf("ping %q: session not found", remote)
+		}
+		return ln.writeControl(flagPong, datagram.Data, remote)
+
+	case datagram.Flags&flagPong != 0:
+		if len(datagram.Data) != 8 {
+			return fmt.Errorf("pong %q: len data %d: %w", remote, len(datagram.Data), ErrShortDatagram)
+		}
+		ln.sessionCond.L.Lock()
+		sess, exists := ln.sessions[remote.String()]
+		ln.sessionCond.L.Unlock()
+		if !exists {
+			return fmt.Errorf("pong %q: session not found", remote)
+		}
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(datagram.Data)))
+		sess.rtt.Store(int64(time.Since(sent)))
+
 	default:
 		ln.sessionCond.L.Lock()
 		sess, exists := ln.sessions[remote.String()]
@@ -401,12 +821,61 @@ func (ln *Listener) handleDatagram(ctx context.Context, remote net.Addr, datagra
 		select {
 		case sess.inbox <- datagram.Data:
 		default:
+			ln.stats.inboxDropped.Add(1)
 		}
 	}
 
 	return nil
 }
 
+// admit opens a session for remote, which asked to join with joinData, and
+// hands it over to Accept.
+func (ln *Listener) admit(remote net.Addr, joinData []byte) {
+	sess := newSession(false, ln.local, remote, ln)
+	sess.joinData = joinData
+	ln.sessionCond.L.Lock()
+	ln.sessions[remote.String()] = sess
+	ln.sessionCond.L.Unlock()
+	ln.sessionCond.Broadcast()
+
+	ln.acceptCh <- sess
+}
+
+// reject refuses to let remote join because of reason, letting it know rather
+// than leaving it waiting for data.
+func (ln *Listener) reject(remote net.Addr, reason error) error {
+	ln.stats.joinRejections.Add(1)
+	err := ln.writeControl(flagLeave, []byte(reason.Error()), remote)
+	return errors.Join(fmt.Errorf("join %q: %w", remote, reason), err)
+}
+
+// challenge draws a nonce for remote to answer before joining with joinData.
+// It must be called with sessionCond.L held.
+func (ln *Listener) challenge(remote net.Addr, joinData []byte) ([]byte, error) {
+	now := time.Now()
+	maps.DeleteFunc(ln.challenges, func(_ string, c challenge) bool { return now.After(c.expires) })
+	if len(ln.challenges) >= maxChallenges {
+		return nil, fmt.Errorf("%d challenges pending", len(ln.challenges))
+	}
+
+	nonce := make([]byte, nonceSize)
+	_, _ = rand.Read(nonce)
+	ln.challenges[remote.String()] = challenge{
+		nonce:    nonce,
+		joinData: joinData,
+		expires:  now.Add(challengeTimeout),
+	}
+	return nonce, nil
+}
+
+// respond returns the answer to the challenge nonce of a listener with
+// password.
+func respond(password, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, password)
+	_, _ = mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
 func (ln *Listener) Close(ctx context.Context) error {
 	ran := false
 	ln.dieOnce.Do(func() {
@@ -443,6 +912,10 @@ type Session struct {
 	inbox  chan []byte
 	outbox chan []byte
 
+	rtt      atomic.Int64 // nanoseconds, 0 until the first pong
+	joinData []byte
+	reason   atomic.Value // string the remote gave for closing the session
+
 	ln      *Listener
 	die     chan struct{}
 	dieOnce sync.Once
@@ -451,14 +924,17 @@ type Session struct {
 func newSession(dial bool, local, remote net.Addr, ln *Listener) *Session {
 	// NOTE: keep fields exhaustive
 	return &Session{
-		dial:    dial,
-		local:   local,
-		remote:  remote,
-		inbox:   make(chan []byte, 1),
-		outbox:  make(chan []byte, 1),
-		ln:      ln,
-		die:     make(chan struct{}),
-		dieOnce: sync.Once{},
+		dial:     dial,
+		local:    local,
+		remote:   remote,
+		inbox:    make(chan []byte, 1),
+		outbox:   make(chan []byte, 1),
+		rtt:      atomic.Int64{},
+		joinData: nil,
+		reason:   atomic.Value{},
+		ln:       ln,
+		die:      make(chan struct{}),
+		dieOnce:  sync.Once{},
 	}
 }
 
@@ -498,24 +974,26 @@ func (sess *Session) TrySend(data []byte) bool {
 	case sess.outbox <- data:
 		return true
 	default:
+		sess.ln.stats.outboxDropped.Add(1)
 		return false
 	}
 }
 
-func (sess *Session) sendLeave(ctx context.Context) error {
+func (sess *Session) sendLeave(ctx context.Context, reason string) error {
 	datagram := Datagram{
 		Version: version,
 		Flags:   flagLeave,
-		Data:    nil,
+		Data:    []byte(reason),
 	}
 	data, err := datagram.MarshalBinary()
 	if err != nil {
 		return err
 	}
-	_, err = writeToWithContext(ctx, sess.ln.logger, sess.ln.conn, data, sess.remote)
+	n, err := writeToWithContext(ctx, sess.ln.logger, sess.ln.conn, data, sess.remote)
 	if err != nil {
 		return err
 	}
+	sess.ln.stats.wrote(n)
 	// TODO: retry of not acknowledged
 	return nil
 }
@@ -537,6 +1015,12 @@ func (sess *Session) partialUncheckedClose(ctx context.Context) error {
 }
 
 func (sess *Session) Close(ctx context.Context) error {
+	return sess.CloseWithReason(ctx, "")
+}
+
+// CloseWithReason closes the session like Close, letting the remote know why
+// through Session.CloseReason.
+func (sess *Session) CloseWithReason(ctx context.Context, reason string) error {
 	var err error
 	ran := false
 	sess.dieOnce.Do(func() {
@@ -547,7 +1031,7 @@ func (sess *Session) Close(ctx context.Context) error {
 		sess.ln.sessionCond.L.Unlock()
 
 		var errs []error
-		errs = append(errs, sess.sendLeave(ctx))
+		errs = append(errs, sess.sendLeave(ctx, reason))
 		errs = append(errs, sess.partialUncheckedClose(ctx))
 
 		err = errors.Join(errs...)
@@ -558,6 +1042,17 @@ func (sess *Session) Close(ctx context.Context) error {
 	return err
 }
 
+// Refuse closes a session just accepted which the application does not let
+// join after all, letting the remote know why like CloseWithReason. It counts
+// towards Stats.JoinRejections like joins refused by the listener itself.
+func (sess *Session) Refuse(ctx context.Context, reason string) error {
+	err := sess.CloseWithReason(ctx, reason)
+	if !errors.Is(err, ErrClosed) {
+		sess.ln.stats.joinRejections.Add(1)
+	}
+	return err
+}
+
 func (sess *Session) Closed() bool {
 	select {
 	case _, open := <-sess.die:
@@ -567,6 +1062,26 @@ func (sess *Session) Closed() bool {
 	}
 }
 
+// RTT returns the last measured round-trip time to the remote, or zero if it
+// has not been measured yet. Both ends ping each other every pingInterval.
+func (sess *Session) RTT() time.Duration {
+	return time.Duration(sess.rtt.Load())
+}
+
+// CloseReason returns why the remote closed the session, such as ErrPassword
+// or ErrBanned as text, or an empty string if it gave no reason or the session
+// is still open.
+func (sess *Session) CloseReason() string {
+	reason, _ := sess.reason.Load().(string)
+	return reason
+}
+
+// JoinData returns the data the remote attached to its request to join; see
+// WithJoinData.
+func (sess *Session) JoinData() []byte {
+	return sess.joinData
+}
+
 func (sess *Session) LocalAddr() net.Addr {
 	return sess.local
 }
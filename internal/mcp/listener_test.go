@@ -3,9 +3,14 @@ package mcp_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log/slog"
 	"multiplayer/internal/mcp"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -234,3 +239,341 @@ func TestListener_one_to_one(t *testing.T) {
 		}
 	})
 }
+
+func TestListener_Ban(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	client, err := mcp.Dial(ctx, server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Ban(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Receive(ctx)
+	if !errors.Is(err, mcp.ErrClosed) {
+		t.Fatalf("expected banned session to be closed; actual error %v", err)
+	}
+	if reason := client.CloseReason(); reason != mcp.ErrBanned.Error() {
+		t.Fatalf("expected reason %q; actual reason %q", mcp.ErrBanned, reason)
+	}
+
+	_, err = mcp.Dial(ctx, server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptCtx, cancelAccept := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelAccept()
+	_, err = server.Accept(acceptCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected join of banned host to be dropped; actual error %v", err)
+	}
+
+	if banned := server.Banned(); len(banned) != 1 || banned[0] != "127.0.0.1" {
+		t.Fatalf("expected banned hosts [127.0.0.1]; actual banned hosts %v", banned)
+	}
+	if stats := server.Stats(); stats.Denied != 1 || stats.JoinRejections != 0 {
+		t.Fatalf("expected the join to be denied without a reply; actual denied %d and join rejections %d",
+			stats.Denied, stats.JoinRejections)
+	}
+}
+
+func TestSession_RTT(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a ping")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	client, err := mcp.Dial(ctx, server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close(ctx) }()
+	sess, err := server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for sess.RTT() == 0 || client.RTT() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("round-trip time was never measured")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestListener_pingWithoutSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	ping, err := mcp.Datagram{Version: 1, Flags: 1 << 2 /* ping */, Data: make([]byte, 8)}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write(ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(make([]byte, 64))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected ping of a remote without a session to go unanswered; actual reply of %d bytes, error %v", n, err)
+	}
+}
+
+func TestSession_JoinData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	client, err := mcp.Dial(ctx, server.LocalAddr().String(), mcp.WithJoinData([]byte("lobby")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close(ctx) }()
+	sess, err := server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := string(sess.JoinData()); data != "lobby" {
+		t.Fatalf("expected join data %q; actual join data %q", "lobby", data)
+	}
+}
+
+func TestListener_password(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	server, err := mcp.Listen("127.0.0.1:", mcp.WithPassword("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	rejected := []struct {
+		opts   []mcp.Option
+		reason error
+	}{
+		{opts: []mcp.Option{mcp.WithPassword("hunter3")}, reason: mcp.ErrPassword},
+		{opts: nil, reason: mcp.ErrNoPassword},
+	}
+	for _, tt := range rejected {
+		client, err := mcp.Dial(ctx, server.LocalAddr().String(), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Receive(ctx)
+		if !errors.Is(err, mcp.ErrClosed) {
+			t.Fatalf("expected join with %v to be refused; actual error %v", tt.reason, err)
+		}
+		if reason := client.CloseReason(); reason != tt.reason.Error() {
+			t.Fatalf("expected reason %q; actual reason %q", tt.reason, reason)
+		}
+	}
+
+	client, err := mcp.Dial(ctx, server.LocalAddr().String(),
+		mcp.WithPassword("hunter2"),
+		mcp.WithJoinData([]byte("lobby")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(sess.JoinData()); data != "lobby" {
+		t.Fatalf("expected join data %q; actual join data %q", "lobby", data)
+	}
+	if rejections := server.Stats().JoinRejections; rejections != 2 {
+		t.Fatalf("expected 2 join rejections; actual join rejections %d", rejections)
+	}
+
+	err = sess.CloseWithReason(ctx, "kicked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Receive(ctx)
+	if !errors.Is(err, mcp.ErrClosed) {
+		t.Fatalf("expected session to be closed; actual error %v", err)
+	}
+	if reason := client.CloseReason(); reason != "kicked" {
+		t.Fatalf("expected reason %q; actual reason %q", "kicked", reason)
+	}
+
+	client, err = mcp.Dial(ctx, server.LocalAddr().String(), mcp.WithPassword("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err = server.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sess.Refuse(ctx, "room full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Receive(ctx)
+	if !errors.Is(err, mcp.ErrClosed) {
+		t.Fatalf("expected session to be closed; actual error %v", err)
+	}
+	if reason := client.CloseReason(); reason != "room full" {
+		t.Fatalf("expected reason %q; actual reason %q", "room full", reason)
+	}
+	if rejections := server.Stats().JoinRejections; rejections != 3 {
+		t.Fatalf("expected 3 join rejections; actual join rejections %d", rejections)
+	}
+}
+
+func TestListener_access(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "access.json")
+	err := os.WriteFile(path, []byte(`{"banned": ["10.0.0.0/8"], "allowed": []}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := mcp.Listen("127.0.0.1:", mcp.WithAccessFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close(ctx) }()
+
+	// joins reports whether a client dialing server is let in. Refused joins
+	// are dropped without a reply, so they are told apart by waiting a bit.
+	joins := func() bool {
+		t.Helper()
+		client, err := mcp.Dial(ctx, server.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close(ctx) }()
+
+		acceptCtx, cancelAccept := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancelAccept()
+		sess, err := server.Accept(acceptCtx)
+		if err != nil {
+			return false
+		}
+		_ = sess.Close(ctx)
+		return true
+	}
+
+	if !joins() {
+		t.Fatal("expected client outside of the banned prefix to join")
+	}
+
+	err = server.Ban(ctx, "127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joins() {
+		t.Fatal("expected client in a banned prefix to be refused")
+	}
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	denied := server.Stats().Denied
+	_, err = conn.Write([]byte("flood"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for server.Stats().Denied == denied {
+		select {
+		case <-ctx.Done():
+			t.Fatal("datagram of banned address was never denied")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if failures := server.Stats().DecodeFailures; failures != 0 {
+		t.Fatalf("expected datagram of banned address to be dropped undecoded; actual decode failures %d", failures)
+	}
+	err = server.Unban(ctx, "127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Allow(ctx, "192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joins() {
+		t.Fatal("expected client outside of the allowed prefixes to be refused")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Banned  []string `json:"banned"`
+		Allowed []string `json:"allowed"`
+	}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(saved.Banned, []string{"10.0.0.0/8"}) || !slices.Equal(saved.Allowed, []string{"192.168.0.0/16"}) {
+		t.Fatalf("expected saved access to be banned [10.0.0.0/8] and allowed [192.168.0.0/16]; actual access %s", data)
+	}
+
+	err = os.WriteFile(path, []byte(`{"banned": [], "allowed": ["127.0.0.1"]}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Reload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if banned, allowed := server.Banned(), server.Allowed(); len(banned) != 0 || !slices.Equal(allowed, []string{"127.0.0.1"}) {
+		t.Fatalf("expected reloaded access to be banned [] and allowed [127.0.0.1]; actual banned %v and allowed %v", banned, allowed)
+	}
+	if !joins() {
+		t.Fatal("expected allowed client to join")
+	}
+}
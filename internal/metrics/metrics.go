@@ -0,0 +1,238 @@
+// Package metrics keeps counters, gauges and histograms, and serves them over
+// HTTP in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry is a set of metrics, written out in the order they were added.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric struct {
+	name, help, typ string
+	write           func(w io.Writer, name string) error
+}
+
+func (r *Registry) add(name, help, typ string, write func(w io.Writer, name string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metric{name: name, help: help, typ: typ, write: write})
+}
+
+// Counter adds a counter, which only ever goes up.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.CounterFunc(name, help, c.Value)
+	return c
+}
+
+// CounterFunc adds a counter whose value is taken from f every time it is
+// written out, for counters kept elsewhere.
+func (r *Registry) CounterFunc(name, help string, f func() uint64) {
+	r.add(name, help, "counter", func(w io.Writer, name string) error {
+		_, err := fmt.Fprintf(w, "%s %d\n", name, f())
+		return err
+	})
+}
+
+// Gauge adds a gauge, which goes up and down.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.GaugeFunc(name, help, g.Value)
+	return g
+}
+
+// GaugeFunc adds a gauge whose value is taken from f every time it is written
+// out.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.add(name, help, "gauge", func(w io.Writer, name string) error {
+		_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(f()))
+		return err
+	})
+}
+
+// GaugeVec adds a family of gauges told apart by the values of labels.
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{labels: labels, gauges: map[string]labeledGauge{}}
+	r.add(name, help, "gauge", v.write)
+	return v
+}
+
+// Histogram adds a histogram counting observations into buckets, which are
+// the sorted upper bounds of each bucket, not counting +Inf.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		buckets: slices.Sorted(slices.Values(buckets)),
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.add(name, help, "histogram", h.write)
+	return h
+}
+
+// Write writes every metric to w in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		if err != nil {
+			return err
+		}
+		err = m.write(w, m.name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves every metric, as is expected of a /metrics endpoint.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+type GaugeVec struct {
+	labels []string
+
+	mu     sync.Mutex
+	gauges map[string]labeledGauge // by the values of the labels joined
+}
+
+type labeledGauge struct {
+	values []string
+	gauge  *Gauge
+}
+
+// With returns the gauge whose labels have values, in the order the labels
+// were given, adding it if needed.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g, ok := v.gauges[key]
+	if !ok {
+		g = labeledGauge{values: values, gauge: &Gauge{}}
+		v.gauges[key] = g
+	}
+	return g.gauge
+}
+
+// Delete removes the gauge whose labels have values, for things which are
+// gone for good.
+func (v *GaugeVec) Delete(values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.gauges, strings.Join(values, "\xff"))
+}
+
+func (v *GaugeVec) write(w io.Writer, name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(v.gauges)) {
+		g := v.gauges[key]
+		pairs := make([]string, len(v.labels))
+		for i, label := range v.labels {
+			var value string
+			if i < len(g.values) {
+				value = g.values[i]
+			}
+			pairs[i] = label + "=" + strconv.Quote(value)
+		}
+		_, err := fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(g.gauge.Value()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		_, err := fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n",
+		name, h.count, name, formatFloat(h.sum), name, h.count)
+	return err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"bytes"
+	"multiplayer/internal/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	var r metrics.Registry
+	c := r.Counter("requests_total", "Requests served.")
+	r.CounterFunc("bytes_total", "Bytes sent.", func() uint64 { return 1024 })
+	g := r.Gauge("temperature", "Current temperature.")
+	v := r.GaugeVec("entities", "Entities by kind.", "room", "kind")
+	h := r.Histogram("latency_seconds", "Request latency.", []float64{0.5, 0.1})
+
+	c.Inc()
+	c.Add(2)
+	g.Set(-1.5)
+	v.With("a", "rocks").Set(3)
+	v.With("a", "birds").Set(1)
+	v.With("b", "rocks").Set(2)
+	v.Delete("b", "rocks")
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.2)
+	h.Observe(1)
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.Write(&buf))
+	assert.Equal(t, `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total 3
+# HELP bytes_total Bytes sent.
+# TYPE bytes_total counter
+bytes_total 1024
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature -1.5
+# HELP entities Entities by kind.
+# TYPE entities gauge
+entities{room="a",kind="birds"} 1
+entities{room="a",kind="rocks"} 3
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="0.5"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 1.35
+latency_seconds_count 4
+`, buf.String())
+}
@@ -0,0 +1,167 @@
+package render
+
+import (
+	"math"
+	"multiplayer/assets"
+	"multiplayer/internal/state"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Camera decides which part of the world shows up on screen.
+type Camera struct {
+	// Center is the position in the world shown at the center of the
+	// screen. In a bounded world, the camera stops at the edges rather than
+	// showing what lies beyond them.
+	Center state.Vec2
+	// Zoom is how many pixels on screen a pixel of the world takes up, so
+	// that cameras zoomed below 1 show more of the world than the screen
+	// would fit otherwise. A zero Zoom stands for 1.
+	Zoom float64
+}
+
+// WholeWorld returns the camera showing all of w on a screen laid out to
+// state.ScreenWidth by state.ScreenHeight, zooming out of worlds larger than
+// that.
+func WholeWorld(w state.World) Camera {
+	return Camera{
+		Center: state.Vec2{X: w.Width / 2, Y: w.Height / 2},
+		Zoom:   min(1, state.ScreenWidth/w.Width, state.ScreenHeight/w.Height),
+	}
+}
+
+// zoom returns the Zoom of cam, standing in 1 for zero.
+func (cam Camera) zoom() float64 {
+	if cam.Zoom <= 0 {
+		return 1
+	}
+	return cam.Zoom
+}
+
+// view returns the size of the part of the world shown on screen.
+func (cam Camera) view(screen *ebiten.Image) (float64, float64) {
+	sw, sh := screenSize(screen)
+	return sw / cam.zoom(), sh / cam.zoom()
+}
+
+// Follow returns the camera moved a step of delta towards target, catching up
+// with it exponentially so that roughly two thirds of the way are covered
+// every smoothing.
+func (cam Camera) Follow(w state.World, target state.Vec2, delta, smoothing time.Duration) Camera {
+	t := 1 - math.Exp(-delta.Seconds()/smoothing.Seconds())
+	cam.Center = w.Lerp(cam.Center, target, t)
+	return cam
+}
+
+// origin returns the position in the world shown at the top left corner of
+// screen.
+func (cam Camera) origin(screen *ebiten.Image, w state.World) state.Vec2 {
+	vw, vh := cam.view(screen)
+	center := cam.Center
+	if !w.Wrap {
+		center.X = clampView(center.X, vw, w.Width)
+		center.Y = clampView(center.Y, vh, w.Height)
+	}
+	return center.Sub(state.Vec2{X: vw / 2, Y: vh / 2})
+}
+
+// clampView keeps a view of length view centered at x within [0, size],
+// centering worlds smaller than the view instead.
+func clampView(x, view, size float64) float64 {
+	if size <= view {
+		return size / 2
+	}
+	return min(max(x, view/2), size-view/2)
+}
+
+// images returns every position on screen at which c shows up. In a wrapping
+// world smaller than the screen, that includes the copies of c across the
+// edges.
+func (cam Camera) images(screen *ebiten.Image, w state.World, c state.Circle) []state.Vec2 {
+	vw, vh := cam.view(screen)
+	zoom := cam.zoom()
+	p := c.Center.Sub(cam.origin(screen, w))
+	if !w.Wrap {
+		if (state.Circle{Center: p, Radius: c.Radius}).Outside(vw, vh) {
+			return nil
+		}
+		return []state.Vec2{p.Mul(zoom)}
+	}
+
+	var images []state.Vec2
+	for x := wrapCoord(p.X, w.Width) - w.Width; x-c.Radius <= vw; x += w.Width {
+		for y := wrapCoord(p.Y, w.Height) - w.Height; y-c.Radius <= vh; y += w.Height {
+			image := state.Circle{Center: state.Vec2{X: x, Y: y}, Radius: c.Radius}
+			if !image.Outside(vw, vh) {
+				images = append(images, image.Center.Mul(zoom))
+			}
+		}
+	}
+	return images
+}
+
+func wrapCoord(x, size float64) float64 {
+	x = math.Mod(x, size)
+	if x < 0 {
+		x += size
+	}
+	return x
+}
+
+func screenSize(screen *ebiten.Image) (float64, float64) {
+	return float64(screen.Bounds().Dx()), float64(screen.Bounds().Dy())
+}
+
+const (
+	indicatorMargin = 40
+	indicatorSize   = 18
+)
+
+// Indicators draws an arrow at the edge of screen pointing at every living
+// player other than self who is off screen.
+func Indicators(screen *ebiten.Image, s state.State, names state.Names, cam Camera, self uint16) {
+	sw, sh := screenSize(screen)
+	vw, vh := cam.view(screen)
+	center := state.Vec2{X: sw / 2, Y: sh / 2}
+	viewCenter := cam.origin(screen, s.World).Add(state.Vec2{X: vw / 2, Y: vh / 2})
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 24}
+
+	for _, player := range s.Players {
+		if player.Dead || player.ID == self {
+			continue
+		}
+		// In a wrapping world, point the shortest way around.
+		p := center.Add(s.World.Delta(viewCenter, player.Trans).Mul(cam.zoom()))
+		if !(state.Circle{Center: p, Radius: state.PlayerRadius * cam.zoom()}).Outside(sw, sh) {
+			continue
+		}
+
+		dir := p.Sub(center)
+		scale := math.Inf(1)
+		if dir.X != 0 {
+			scale = min(scale, (sw/2-indicatorMargin)/math.Abs(dir.X))
+		}
+		if dir.Y != 0 {
+			scale = min(scale, (sh/2-indicatorMargin)/math.Abs(dir.Y))
+		}
+		edge := center.Add(dir.Mul(scale))
+		forward := dir.Normalize().Mul(indicatorSize)
+		side := state.Vec2{X: -forward.Y, Y: forward.X}
+
+		clr := teamColor(s, player.Team)
+		tip := edge.Add(forward)
+		for _, wing := range [...]state.Vec2{edge.Sub(forward).Add(side), edge.Sub(forward).Sub(side)} {
+			vector.StrokeLine(screen, float32(wing.X), float32(wing.Y), float32(tip.X), float32(tip.Y), 4, clr, true)
+		}
+
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(edge.X-2*forward.X, edge.Y-2*forward.Y)
+		op.PrimaryAlign = text.AlignCenter
+		op.SecondaryAlign = text.AlignCenter
+		op.ColorScale.ScaleWithColor(clr)
+		text.Draw(screen, names.Of(player.ID), face, op)
+	}
+}
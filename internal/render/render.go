@@ -0,0 +1,440 @@
+// Package render draws states, shared by the game and the window of the
+// simulation.
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"multiplayer/assets"
+	"multiplayer/internal/leaderboard"
+	"multiplayer/internal/state"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// State draws every entity of s seen through cam onto screen, including the
+// copies of those straddling the edges of a wrapping world, topped by the HUD.
+// Players are labelled with their names.
+func State(screen *ebiten.Image, s state.State, names state.Names, cam Camera) {
+	zoom := cam.zoom()
+	for _, bullet := range s.Bullets {
+		var cs ebiten.ColorScale
+		if bullet.Owner == state.OwnerHostile {
+			cs.ScaleWithColor(HostileColor)
+		} else if owner, ok := player(s, bullet.Owner); ok {
+			cs = teamColorScale(s, owner.Team)
+		}
+		for _, center := range cam.images(screen, s.World, bullet.Shape()) {
+			var m ebiten.GeoM
+			bounds := assets.Bullet.Bounds()
+			m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
+			m.Scale(
+				state.BulletWidth/float64(bounds.Dx()),
+				state.BulletHeight/float64(bounds.Dy()),
+			)
+			m.Scale(zoom, zoom)
+			m.Translate(center.X, center.Y)
+			screen.DrawImage(assets.Bullet, &ebiten.DrawImageOptions{GeoM: m, ColorScale: cs})
+		}
+	}
+
+	for _, asteroid := range s.Asteroids {
+		for _, center := range cam.images(screen, s.World, asteroid.Shape()) {
+			var m ebiten.GeoM
+			bounds := assets.Rock.Bounds()
+			m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
+			m.Rotate(asteroid.Rotation)
+			m.Scale(
+				state.AsteroidWidth*asteroid.Size.Scale()/float64(bounds.Dx()),
+				state.AsteroidHeight*asteroid.Size.Scale()/float64(bounds.Dy()),
+			)
+			m.Scale(zoom, zoom)
+			m.Translate(center.X, center.Y)
+			screen.DrawImage(assets.Rock, &ebiten.DrawImageOptions{GeoM: m})
+		}
+	}
+
+	for _, ufo := range s.UFOs {
+		for _, center := range cam.images(screen, s.World, ufo.Shape()) {
+			ufoShape(screen, center, ufo.Rotation, zoom)
+		}
+	}
+
+	for _, powerUp := range s.PowerUps {
+		for _, center := range cam.images(screen, s.World, powerUp.Shape()) {
+			cx, cy, z := float32(center.X), float32(center.Y), float32(zoom)
+			vector.DrawFilledCircle(screen, cx, cy, state.PowerUpRadius*z, PowerUpColors[powerUp.Kind], true)
+			vector.StrokeCircle(screen, cx, cy, state.PowerUpRadius*z, 3*z, color.White, true)
+
+			op := &text.DrawOptions{}
+			op.GeoM.Rotate(powerUp.Rotation)
+			op.GeoM.Scale(zoom, zoom)
+			op.GeoM.Translate(center.X, center.Y)
+			op.PrimaryAlign = text.AlignCenter
+			op.SecondaryAlign = text.AlignCenter
+			text.Draw(screen, powerUpLetters[powerUp.Kind], &text.GoTextFace{
+				Source: assets.MPlus1pRegular,
+				Size:   28,
+			}, op)
+		}
+	}
+
+	for _, player := range s.Players {
+		if player.Dead {
+			continue
+		}
+
+		cs := teamColorScale(s, player.Team)
+		if player.Invulnerable > 0 && time.Now().UnixMilli()/blinkPeriod%2 == 0 {
+			cs.ScaleAlpha(0.3)
+		}
+		for _, center := range cam.images(screen, s.World, player.Shape()) {
+			var m ebiten.GeoM
+			bounds := assets.Player.Bounds()
+			m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
+			m.Rotate(player.Rotation)
+			m.Scale(
+				state.PlayerWidth/float64(bounds.Dx()),
+				state.PlayerHeight/float64(bounds.Dy()),
+			)
+			m.Scale(zoom, zoom)
+			m.Translate(center.X, center.Y)
+			screen.DrawImage(assets.Player, &ebiten.DrawImageOptions{
+				GeoM:       m,
+				ColorScale: cs,
+			})
+
+			op := &text.DrawOptions{}
+			op.GeoM.Scale(zoom, zoom)
+			op.GeoM.Translate(center.X, center.Y-state.PlayerHeight*zoom)
+			op.PrimaryAlign = text.AlignCenter
+			op.SecondaryAlign = text.AlignEnd
+			op.ColorScale = cs
+			text.Draw(screen, names.Of(player.ID), &text.GoTextFace{
+				Source: assets.MPlus1pRegular,
+				Size:   40,
+			}, op)
+
+			effects(screen, player, center, zoom)
+		}
+	}
+
+	hud(screen, s, names)
+}
+
+// HostileColor is the color of UFOs and their bullets.
+var HostileColor = color.RGBA{R: 0x60, G: 0xff, B: 0x60, A: 0xff}
+
+// ufoShape draws a flying saucer centered at center and scaled by zoom, with
+// the lights around its rim turned by rotation.
+func ufoShape(screen *ebiten.Image, center state.Vec2, rotation, zoom float64) {
+	const lights = 6
+
+	cx, cy, r := float32(center.X), float32(center.Y), float32(state.UFORadius*zoom)
+	vector.DrawFilledCircle(screen, cx, cy-r/4, r/2, color.RGBA{R: 0xa0, G: 0xd0, B: 0xff, A: 0xc0}, true)
+	vector.DrawFilledRect(screen, cx-r, cy-r/6, 2*r, r/2, HostileColor, true)
+	vector.StrokeRect(screen, cx-r, cy-r/6, 2*r, r/2, 2*float32(zoom), color.White, true)
+	for i := range lights {
+		// lights run along the rim, squashed into a line
+		x := math.Cos(rotation + 2*math.Pi*float64(i)/lights)
+		vector.DrawFilledCircle(screen, cx+float32(x)*r*0.8, cy+r/12, 3*float32(zoom), color.White, true)
+	}
+}
+
+// PowerUpColors are the colors of the power-ups and of the indicators of their
+// effects.
+var PowerUpColors = [state.PowerUpKindCount]color.RGBA{
+	state.PowerUpShield:     {R: 0x40, G: 0xe0, B: 0xff, A: 0xff},
+	state.PowerUpRapidFire:  {R: 0xff, G: 0xc0, B: 0x30, A: 0xff},
+	state.PowerUpSpreadShot: {R: 0xc0, G: 0x60, B: 0xff, A: 0xff},
+}
+
+var powerUpLetters = [state.PowerUpKindCount]string{
+	state.PowerUpShield:     "S",
+	state.PowerUpRapidFire:  "R",
+	state.PowerUpSpreadShot: "W",
+}
+
+// effectWarning is the time left at which effects start blinking to warn that
+// they are about to run out.
+const effectWarning = 2 * time.Second
+
+// effects draws the indicators of the active effects of player centered at
+// center and scaled by zoom: a ring for the shield, and the time left of every
+// effect below the ship.
+func effects(screen *ebiten.Image, player state.Player, center state.Vec2, zoom float64) {
+	blink := time.Now().UnixMilli()/blinkPeriod%2 == 0
+
+	if left := player.Effects[state.PowerUpShield]; left > 0 && (left > effectWarning || blink) {
+		vector.StrokeCircle(screen,
+			float32(center.X), float32(center.Y), float32((state.PlayerRadius+8)*zoom), float32(4*zoom),
+			PowerUpColors[state.PowerUpShield], true)
+	}
+
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 24}
+	y := center.Y + (state.PlayerRadius+12)*zoom
+	for kind, left := range player.Effects {
+		if left <= 0 {
+			continue
+		}
+		op := &text.DrawOptions{}
+		op.GeoM.Scale(zoom, zoom)
+		op.GeoM.Translate(center.X, y)
+		op.PrimaryAlign = text.AlignCenter
+		op.ColorScale.ScaleWithColor(PowerUpColors[kind])
+		if left <= effectWarning && blink {
+			op.ColorScale.ScaleAlpha(0.3)
+		}
+		text.Draw(screen, fmt.Sprintf("%s %.0fs", state.PowerUpKind(kind), math.Ceil(left.Seconds())), face, op)
+		y += face.Size * zoom
+	}
+}
+
+// TeamColors are the colors of the teams in state.ModeTeamDeathmatch.
+var TeamColors = [state.TeamCount]color.RGBA{
+	{R: 0xff, G: 0x60, B: 0x60, A: 0xff},
+	{R: 0x60, G: 0xa0, B: 0xff, A: 0xff},
+}
+
+var teamNames = [state.TeamCount]string{"Red", "Blue"}
+
+func teamColor(s state.State, team uint8) color.Color {
+	if s.Mode == state.ModeTeamDeathmatch {
+		return TeamColors[team]
+	}
+	return color.White
+}
+
+func teamColorScale(s state.State, team uint8) ebiten.ColorScale {
+	var cs ebiten.ColorScale
+	if s.Mode == state.ModeTeamDeathmatch {
+		cs.ScaleWithColor(TeamColors[team])
+	}
+	return cs
+}
+
+func player(s state.State, id uint16) (state.Player, bool) {
+	for _, player := range s.Players {
+		if player.ID == id {
+			return player, true
+		}
+	}
+	return state.Player{}, false
+}
+
+// hud draws the scores in the top left corner and the mode in the top right
+// one.
+func hud(screen *ebiten.Image, s state.State, names state.Names) {
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 60}
+
+	if s.Mode == state.ModeTeamDeathmatch {
+		x := 0.0
+		for i, team := range s.Teams {
+			op := &text.DrawOptions{}
+			op.GeoM.Translate(x, 0)
+			op.ColorScale.ScaleWithColor(TeamColors[i])
+			line := fmt.Sprintf("%s: %d  ", teamNames[i], team.Score)
+			text.Draw(screen, line, face, op)
+			x += text.Advance(line, face)
+		}
+	} else {
+		text.Draw(
+			screen,
+			fmt.Sprintf("Total Score: %d", s.TotalScore),
+			face,
+			&text.DrawOptions{},
+		)
+	}
+
+	mode := map[state.Mode]string{
+		state.ModeCoop:           "Co-op",
+		state.ModeFreeForAll:     "Free-for-all",
+		state.ModeTeamDeathmatch: "Team deathmatch",
+	}[s.Mode]
+	if s.Mode == state.ModeTeamDeathmatch && s.FriendlyFire {
+		mode += " (friendly fire)"
+	}
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(screen.Bounds().Dx()), 0)
+	op.PrimaryAlign = text.AlignEnd
+	text.Draw(screen, mode, &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 36}, op)
+
+	scoreboard(screen, s, names)
+	banner(screen, s, names)
+}
+
+// banner announces the current phase of the round in the middle of the
+// screen, and the results once the game is over.
+func banner(screen *ebiten.Image, s state.State, names state.Names) {
+	var title, subtitle string
+	switch {
+	case s.Paused:
+		title = "Paused"
+		subtitle = "by the server"
+	case s.Phase == state.PhaseBreather:
+		title = fmt.Sprintf("Wave %d", s.Wave+1)
+		subtitle = fmt.Sprintf("starting in %.0fs", math.Ceil(s.PhaseTime.Seconds()))
+	case s.Phase == state.PhaseGameOver:
+		title = "Game Over"
+		subtitle = fmt.Sprintf("%s  -  restarting in %.0fs", results(s, names), math.Ceil(s.PhaseTime.Seconds()))
+	default:
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(float64(screen.Bounds().Dx()), 40)
+		op.PrimaryAlign = text.AlignEnd
+		text.Draw(screen, fmt.Sprintf("Wave %d", s.Wave), &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 36}, op)
+		return
+	}
+
+	cx, cy := float64(screen.Bounds().Dx())/2, float64(screen.Bounds().Dy())/2
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(cx, cy-100)
+	op.PrimaryAlign = text.AlignCenter
+	text.Draw(screen, title, &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 120}, op)
+
+	op = &text.DrawOptions{}
+	op.GeoM.Translate(cx, cy+40)
+	op.PrimaryAlign = text.AlignCenter
+	text.Draw(screen, subtitle, &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 48}, op)
+}
+
+// Message draws a message from the server at the top of screen.
+func Message(screen *ebiten.Image, msg string) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(screen.Bounds().Dx())/2, 100)
+	op.PrimaryAlign = text.AlignCenter
+	op.ColorScale.ScaleWithColor(color.RGBA{R: 0xff, G: 0xe0, B: 0x60, A: 0xff})
+	text.Draw(screen, msg, &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 36}, op)
+}
+
+// ChatLine is a line of the chat log, faded out to Alpha.
+type ChatLine struct {
+	state.Chat
+	Alpha float32
+}
+
+// SystemColor is the color of what the server says in the chat.
+var SystemColor = color.RGBA{R: 0xa0, G: 0xa0, B: 0xa0, A: 0xff}
+
+// Chat draws the chat log in the bottom left corner of screen, the latest line
+// at the bottom, topped by draft as it is being typed.
+func Chat(screen *ebiten.Image, log []ChatLine, draft string, typing bool) {
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 30}
+	lineHeight := face.Size * 1.2
+	y := float64(screen.Bounds().Dy()) - lineHeight
+	if typing {
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(10, y)
+		text.Draw(screen, "> "+draft+"_", face, op)
+		y -= lineHeight
+	}
+	for i := len(log) - 1; i >= 0; i-- {
+		line := log[i]
+		if line.Alpha <= 0 {
+			continue
+		}
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(10, y)
+		msg := line.Name + ": " + line.Text
+		if line.System() {
+			op.ColorScale.ScaleWithColor(SystemColor)
+			msg = line.Text
+		}
+		op.ColorScale.ScaleAlpha(line.Alpha)
+		text.Draw(screen, msg, face, op)
+		y -= lineHeight
+	}
+}
+
+// Leaderboard draws the best scores over the middle of screen, or that they
+// are on their way until loaded.
+func Leaderboard(screen *ebiten.Image, top []leaderboard.Entry, loaded bool) {
+	sw, sh := float32(screen.Bounds().Dx()), float32(screen.Bounds().Dy())
+	vector.DrawFilledRect(screen, sw/4, sh/8, sw/2, sh*3/4, color.RGBA{A: 0xc0}, false)
+
+	cx := float64(sw) / 2
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(cx, float64(sh)/8+20)
+	op.PrimaryAlign = text.AlignCenter
+	text.Draw(screen, "Leaderboard", &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 72}, op)
+
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 36}
+	lines := make([]string, 0, len(top))
+	for i, entry := range top {
+		lines = append(lines, fmt.Sprintf("%2d. %-16s %8d  %s", i+1, entry.Name, entry.Score, entry.Time.Format(time.DateOnly)))
+	}
+	switch {
+	case !loaded:
+		lines = []string{"loading..."}
+	case len(lines) == 0:
+		lines = []string{"no scores yet"}
+	}
+	for i, line := range lines {
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(cx, float64(sh)/8+120+float64(i)*face.Size*1.2)
+		op.PrimaryAlign = text.AlignCenter
+		text.Draw(screen, line, face, op)
+	}
+}
+
+// results sums up how the game went.
+func results(s state.State, names state.Names) string {
+	switch s.Mode {
+	case state.ModeTeamDeathmatch:
+		winner := 0
+		for i, team := range s.Teams {
+			if team.Score > s.Teams[winner].Score {
+				winner = i
+			}
+		}
+		return fmt.Sprintf("%s team wins", teamNames[winner])
+	case state.ModeFreeForAll:
+		var best *state.Player
+		for i := range s.Players {
+			if best == nil || s.Players[i].Score > best.Score {
+				best = &s.Players[i]
+			}
+		}
+		if best != nil {
+			return fmt.Sprintf("%s wins", names.Of(best.ID))
+		}
+	}
+	return fmt.Sprintf("reached wave %d with %d pts", s.Wave, s.TotalScore)
+}
+
+// blinkPeriod is the period in milliseconds at which invulnerable players
+// blink.
+const blinkPeriod = 125
+
+// scoreboard lists the score, lives and status of every player below the
+// headline scores.
+func scoreboard(screen *ebiten.Image, s state.State, names state.Names) {
+	face := &text.GoTextFace{Source: assets.MPlus1pRegular, Size: 36}
+	for i, player := range s.Players {
+		status := ""
+		switch {
+		case player.Dead && player.Lives == 0:
+			status = "out"
+		case player.Dead:
+			status = fmt.Sprintf("respawning in %.1fs", player.Respawn.Seconds())
+		case player.Invulnerable > 0:
+			status = "invulnerable"
+		}
+
+		line := fmt.Sprintf("%s: %d pts, %d lives", names.Of(player.ID), player.Score, player.Lives)
+		if s.Mode != state.ModeCoop {
+			line += fmt.Sprintf(", %d/%d K/D", player.Kills, player.Deaths)
+		}
+
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(0, 80+float64(i)*face.Size*1.2)
+		op.ColorScale = teamColorScale(s, player.Team)
+		if player.Dead {
+			op.ColorScale.ScaleAlpha(0.5)
+		}
+		text.Draw(screen, line+" "+status, face, op)
+	}
+}
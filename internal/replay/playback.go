@@ -0,0 +1,181 @@
+package replay
+
+import (
+	"errors"
+	"io"
+	"math"
+	"multiplayer/internal/state"
+	"time"
+)
+
+const (
+	MinSpeed = 0.25
+	MaxSpeed = 4.0
+)
+
+// Playback plays a replay back on a clock of its own, which can be paused,
+// moved around and sped up or slowed down. It reads the frames as the clock
+// reaches them, and reads the replay again from the start to go back.
+type Playback struct {
+	rr    *Reader
+	first uint32  // tick of the first frame
+	last  uint32  // tick of the last frame
+	ticks float64 // since the first frame
+
+	// prev and next are the frames around the clock, with no next past the
+	// last frame.
+	prev    Frame
+	next    Frame
+	hasNext bool
+
+	speed  float64
+	paused bool
+}
+
+// NewPlayback reads the replay through once to find how long it lasts, and
+// starts playing it from the first frame.
+func NewPlayback(rr *Reader) (*Playback, error) {
+	p := &Playback{
+		rr:      rr,
+		first:   0,
+		last:    0,
+		ticks:   0,
+		prev:    Frame{},
+		next:    Frame{},
+		hasNext: false,
+		speed:   1,
+		paused:  false,
+	}
+	frames := 0
+	for {
+		frame, err := rr.next(false)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if frames == 0 {
+			p.first = frame.Tick
+		}
+		p.last = frame.Tick
+		frames++
+	}
+	if frames == 0 {
+		return nil, errors.New("replay has no states")
+	}
+
+	err := p.rewind()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Advance moves the clock along by d of real time, scaled by the speed,
+// unless paused.
+func (p *Playback) Advance(d time.Duration) {
+	if p.paused {
+		return
+	}
+	p.setTicks(p.ticks + d.Seconds()*float64(p.rr.TPS())*p.speed)
+}
+
+// Seek moves the clock by d of replay time, backwards if d is negative.
+func (p *Playback) Seek(d time.Duration) {
+	p.setTicks(p.ticks + d.Seconds()*float64(p.rr.TPS()))
+}
+
+func (p *Playback) setTicks(ticks float64) {
+	p.ticks = min(max(ticks, 0), p.lastTicks())
+
+	target := float64(p.first) + p.ticks
+	if target < float64(p.prev.Tick) {
+		// The replay was read whole by NewPlayback, so it only fails to
+		// read again if the file changed since, and then plays no further.
+		err := p.rewind()
+		if err != nil {
+			p.hasNext = false
+			return
+		}
+	}
+	for p.hasNext && float64(p.next.Tick) <= target {
+		p.prev = p.next
+		p.readNext()
+	}
+}
+
+// rewind reads the first two frames again.
+func (p *Playback) rewind() error {
+	err := p.rr.Rewind()
+	if err != nil {
+		return err
+	}
+	p.prev, err = p.rr.Next()
+	if err != nil {
+		return err
+	}
+	p.readNext()
+	return nil
+}
+
+func (p *Playback) readNext() {
+	next, err := p.rr.Next()
+	p.next, p.hasNext = next, err == nil
+}
+
+func (p *Playback) lastTicks() float64 {
+	return float64(p.last - p.first)
+}
+
+// Position returns how far into the replay the clock is.
+func (p *Playback) Position() time.Duration {
+	return p.ticksToDuration(p.ticks)
+}
+
+// Length returns how long the replay lasts.
+func (p *Playback) Length() time.Duration {
+	return p.ticksToDuration(p.lastTicks())
+}
+
+func (p *Playback) ticksToDuration(ticks float64) time.Duration {
+	return time.Duration(ticks / float64(p.rr.TPS()) * float64(time.Second))
+}
+
+func (p *Playback) Speed() float64 {
+	return p.speed
+}
+
+// SetSpeed sets the speed, kept within MinSpeed and MaxSpeed.
+func (p *Playback) SetSpeed(speed float64) {
+	p.speed = min(max(speed, MinSpeed), MaxSpeed)
+}
+
+func (p *Playback) Paused() bool {
+	return p.paused
+}
+
+func (p *Playback) SetPaused(paused bool) {
+	p.paused = paused
+}
+
+// Done reports whether the clock reached the end of the replay.
+func (p *Playback) Done() bool {
+	return p.ticks >= p.lastTicks()
+}
+
+// State returns the state at the clock, interpolated between the frames
+// around it the way clients interpolate snapshots.
+func (p *Playback) State() state.State {
+	if !p.hasNext {
+		return p.prev.State
+	}
+	target := float64(p.first) + p.ticks
+	t := (target - float64(p.prev.Tick)) / float64(p.next.Tick-p.prev.Tick)
+	return p.prev.State.Lerp(p.next.State, math.Min(t, 1))
+}
+
+// Names returns the names of the players at the clock.
+func (p *Playback) Names() state.Names {
+	return p.prev.Names
+}
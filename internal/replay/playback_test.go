@@ -0,0 +1,80 @@
+package replay_test
+
+import (
+	"bytes"
+	"multiplayer/internal/replay"
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// linear returns a replay of a player moving right by 10 every tick, for a
+// second at 10 TPS, with the frame of tick 5 missing and the player named
+// from tick 8.
+func linear(t *testing.T) *replay.Reader {
+	var buf bytes.Buffer
+	rw, err := replay.NewWriter(&buf, 10)
+	assert.NoError(t, err)
+	s := state.Init()
+	s.AddPlayer("a")
+	names := state.Names{}
+	for tick := range uint32(11) {
+		if tick == 8 {
+			names = state.Names{s.Players[0].ID: "Ripley"}
+		}
+		if tick == 5 {
+			continue
+		}
+		s.Players[0].Trans = state.Vec2{X: float64(10 * tick), Y: 100}
+		var encoded bytes.Buffer
+		s.Encode(&encoded)
+		assert.NoError(t, rw.Write(tick, s.Rules, names, encoded.Bytes()))
+	}
+	assert.NoError(t, rw.Close())
+
+	rr, err := replay.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	return rr
+}
+
+func TestPlayback(t *testing.T) {
+	p, err := replay.NewPlayback(linear(t))
+	if !assert.NoError(t, err) {
+		return
+	}
+	x := func() float64 { return p.State().Players[0].Trans.X }
+	assert.Equal(t, time.Second, p.Length())
+	assert.Equal(t, 0.0, x())
+
+	p.Advance(250 * time.Millisecond)
+	assert.InDelta(t, 25, x(), 1e-9)
+
+	// across the missing frame
+	p.Advance(250 * time.Millisecond)
+	assert.InDelta(t, 50, x(), 1e-9)
+
+	p.SetPaused(true)
+	p.Advance(time.Second)
+	assert.InDelta(t, 50, x(), 1e-9)
+	p.SetPaused(false)
+
+	p.SetSpeed(10)
+	assert.Equal(t, replay.MaxSpeed, p.Speed())
+	p.Advance(100 * time.Millisecond)
+	assert.InDelta(t, 90, x(), 1e-9)
+	assert.Equal(t, "Ripley", p.Names().Of(p.State().Players[0].ID))
+
+	p.Seek(time.Minute)
+	assert.True(t, p.Done())
+	assert.Equal(t, 100.0, x())
+
+	p.Seek(-time.Minute)
+	assert.Equal(t, time.Duration(0), p.Position())
+	assert.Equal(t, 0.0, x())
+	assert.Empty(t, p.Names())
+
+	p.SetSpeed(0)
+	assert.Equal(t, replay.MinSpeed, p.Speed())
+}
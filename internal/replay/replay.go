@@ -0,0 +1,320 @@
+// Package replay records the snapshots broadcast by a room into a file and
+// plays them back.
+//
+// A replay file starts with the magic "ASTRPLAY", a big endian uint16 version
+// and the uint16 TPS of the recording, followed by a gzip stream of records.
+// Every record is a kind byte, a uint32 tick, a uint32 length and as many
+// bytes of data: the encoded state.Rules or state.Names in force from then on,
+// or an encoded state.State.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"multiplayer/internal/state"
+	"os"
+)
+
+const magic = "ASTRPLAY"
+
+// Version is the version of the replay files written by this package. It
+// also reads the earlier versions, which only lack the names.
+const Version uint16 = 2
+
+var (
+	ErrFormat  = errors.New("not a replay file")
+	ErrVersion = errors.New("unsupported replay version")
+)
+
+// maxRecordSize keeps corrupt lengths from running out of memory.
+const maxRecordSize = 1 << 20
+
+type recordKind byte
+
+const (
+	recordRules recordKind = iota + 1
+	recordState
+	recordNames
+)
+
+// Writer writes a replay file.
+type Writer struct {
+	zw     *gzip.Writer
+	closer io.Closer
+
+	rules    state.Rules
+	hasRules bool
+	names    state.Names
+	hasNames bool
+}
+
+// NewWriter writes the header of a replay recorded at tps to w.
+func NewWriter(w io.Writer, tps int) (*Writer, error) {
+	var header bytes.Buffer
+	_, _ = header.WriteString(magic)
+	_ = binary.Write(&header, binary.BigEndian, Version)
+	_ = binary.Write(&header, binary.BigEndian, uint16(tps))
+	_, err := w.Write(header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		zw:       gzip.NewWriter(w),
+		closer:   nil,
+		rules:    state.Rules{},
+		hasRules: false,
+		names:    state.Names{},
+		hasNames: false,
+	}, nil
+}
+
+// Create creates the replay file at path.
+func Create(path string, tps int) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rw, err := NewWriter(f, tps)
+	if err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	rw.closer = f
+	return rw, nil
+}
+
+// Write records the state encoded at tick, which is decoded with rules, along
+// with the names of its players.
+func (rw *Writer) Write(tick uint32, rules state.Rules, names state.Names, encoded []byte) error {
+	if !rw.hasRules || rules != rw.rules {
+		var buf bytes.Buffer
+		rules.Encode(&buf)
+		err := rw.record(recordRules, tick, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		rw.rules = rules
+		rw.hasRules = true
+	}
+	if !rw.hasNames || !maps.Equal(names, rw.names) {
+		var buf bytes.Buffer
+		names.Encode(&buf)
+		err := rw.record(recordNames, tick, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		rw.names = maps.Clone(names)
+		rw.hasNames = true
+	}
+	return rw.record(recordState, tick, encoded)
+}
+
+func (rw *Writer) record(kind recordKind, tick uint32, data []byte) error {
+	header := make([]byte, 0, 1+4+4)
+	header = append(header, byte(kind))
+	header = binary.BigEndian.AppendUint32(header, tick)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(data)))
+	_, err := rw.zw.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = rw.zw.Write(data)
+	return err
+}
+
+// Close finishes the replay, and closes the file if it was made by Create.
+func (rw *Writer) Close() error {
+	err := rw.zw.Close()
+	if rw.closer != nil {
+		err = errors.Join(err, rw.closer.Close())
+	}
+	return err
+}
+
+// Frame is a state recorded at Tick, with the names of its players.
+type Frame struct {
+	Tick  uint32
+	State state.State
+	Names state.Names
+}
+
+// Reader reads the frames of a replay one after the other, so that no more
+// than the latest of them are held in memory however long the match. A replay
+// cut short, such as by the server crashing, ends where it was cut.
+type Reader struct {
+	src io.ReadSeeker
+	tps int
+	zr  *gzip.Reader
+
+	rules    state.Rules
+	hasRules bool
+	names    state.Names
+	tick     uint32 // of the last frame read
+	hasTick  bool
+	closer   io.Closer
+}
+
+// NewReader reads the header of the replay in src.
+func NewReader(src io.ReadSeeker) (*Reader, error) {
+	rr := &Reader{
+		src:      src,
+		tps:      0,
+		zr:       nil,
+		rules:    state.Rules{},
+		hasRules: false,
+		names:    state.Names{},
+		tick:     0,
+		hasTick:  false,
+		closer:   nil,
+	}
+	err := rr.Rewind()
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// Open opens the replay file at path, which stays open until Close.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := NewReader(f)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("replay %q: %w", path, err), f.Close())
+	}
+	rr.closer = f
+	return rr, nil
+}
+
+// TPS returns the ticks per second the replay was recorded at.
+func (rr *Reader) TPS() int {
+	return rr.tps
+}
+
+// Rewind goes back to the first frame.
+func (rr *Reader) Rewind() error {
+	_, err := rr.src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(rr.src)
+	header := make([]byte, len(magic)+2+2)
+	_, err = io.ReadFull(br, header)
+	if err != nil || string(header[:len(magic)]) != magic {
+		return ErrFormat
+	}
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v == 0 || v > Version {
+		return fmt.Errorf("version %d: %w", v, ErrVersion)
+	}
+	rr.tps = int(binary.BigEndian.Uint16(header[len(magic)+2:]))
+	if rr.tps == 0 {
+		return fmt.Errorf("tps 0: %w", ErrFormat)
+	}
+
+	if rr.zr == nil {
+		rr.zr, err = gzip.NewReader(br)
+	} else {
+		err = rr.zr.Reset(br)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	rr.rules = state.Rules{}
+	rr.hasRules = false
+	rr.names = state.Names{}
+	rr.tick = 0
+	rr.hasTick = false
+	return nil
+}
+
+// Next reads the next frame, or returns io.EOF past the last one.
+func (rr *Reader) Next() (Frame, error) {
+	return rr.next(true)
+}
+
+// next reads the next frame, leaving its state out unless decode.
+func (rr *Reader) next(decode bool) (Frame, error) {
+	for {
+		kind, tick, data, err := readRecord(rr.zr)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+
+		switch kind {
+		case recordRules:
+			err = rr.rules.Decode(bytes.NewReader(data))
+			if err != nil {
+				return Frame{}, fmt.Errorf("rules at tick %d: %w", tick, err)
+			}
+			rr.hasRules = true
+		case recordNames:
+			// Frames share the names until they change, so they are
+			// decoded into new ones.
+			var names state.Names
+			err = names.Decode(bytes.NewReader(data))
+			if err != nil {
+				return Frame{}, fmt.Errorf("names at tick %d: %w", tick, err)
+			}
+			rr.names = names
+		case recordState:
+			if !rr.hasRules {
+				return Frame{}, fmt.Errorf("state at tick %d: no rules recorded before", tick)
+			}
+			if rr.hasTick && tick <= rr.tick {
+				return Frame{}, fmt.Errorf("state at tick %d: not after tick %d", tick, rr.tick)
+			}
+			var s state.State
+			if decode {
+				s.SetRules(rr.rules)
+				err = s.Decode(bytes.NewReader(data))
+				if err != nil {
+					return Frame{}, fmt.Errorf("state at tick %d: %w", tick, err)
+				}
+			}
+			rr.tick = tick
+			rr.hasTick = true
+			return Frame{Tick: tick, State: s, Names: rr.names}, nil
+		default:
+			return Frame{}, fmt.Errorf("record kind %d at tick %d: unknown kind", kind, tick)
+		}
+	}
+}
+
+// Close closes the file if the replay was opened by Open.
+func (rr *Reader) Close() error {
+	if rr.closer == nil {
+		return nil
+	}
+	return rr.closer.Close()
+}
+
+func readRecord(r io.Reader) (recordKind, uint32, []byte, error) {
+	header := make([]byte, 1+4+4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	kind := recordKind(header[0])
+	tick := binary.BigEndian.Uint32(header[1:])
+	size := binary.BigEndian.Uint32(header[5:])
+	if size > maxRecordSize {
+		return 0, 0, nil, fmt.Errorf("record of %d bytes at tick %d: %w", size, tick, ErrFormat)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return kind, tick, data, err
+}
@@ -0,0 +1,113 @@
+package replay_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"multiplayer/internal/replay"
+	"multiplayer/internal/state"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// record writes a replay of a player moving right by 10 every tick, with the
+// world growing and the player picking a name halfway through.
+func record(t *testing.T, ticks int) []byte {
+	var buf bytes.Buffer
+	rw, err := replay.NewWriter(&buf, 30)
+	assert.NoError(t, err)
+
+	s := state.Init()
+	s.AddPlayer("a")
+	names := state.Names{}
+	for tick := range ticks {
+		if tick == ticks/2 {
+			rules := s.Rules
+			rules.WorldWidth = 4000
+			s.SetRules(rules)
+			names = state.Names{s.Players[0].ID: "Ripley"}
+		}
+		s.Players[0].Trans = state.Vec2{X: float64(10 * tick), Y: 100}
+		var encoded bytes.Buffer
+		s.Encode(&encoded)
+		assert.NoError(t, rw.Write(uint32(tick), s.Rules, names, encoded.Bytes()))
+	}
+	assert.NoError(t, rw.Close())
+	return buf.Bytes()
+}
+
+// readAll reads the frames left in rr.
+func readAll(t *testing.T, rr *replay.Reader) []replay.Frame {
+	var frames []replay.Frame
+	for {
+		frame, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if !assert.NoError(t, err) {
+			return frames
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestReader(t *testing.T) {
+	rr, err := replay.NewReader(bytes.NewReader(record(t, 10)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 30, rr.TPS())
+
+	frames := readAll(t, rr)
+	if assert.Len(t, frames, 10) {
+		for i, frame := range frames {
+			assert.Equal(t, uint32(i), frame.Tick)
+			assert.Equal(t, state.Vec2{X: float64(10 * i), Y: 100}, frame.State.Players[0].Trans)
+		}
+		id := frames[0].State.Players[0].ID
+		assert.Equal(t, float64(state.ScreenWidth), frames[4].State.World.Width)
+		assert.Equal(t, 4000.0, frames[5].State.World.Width)
+		assert.Empty(t, frames[4].Names)
+		assert.Equal(t, state.Names{id: "Ripley"}, frames[5].Names)
+	}
+
+	assert.NoError(t, rr.Rewind())
+	frame, err := rr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), frame.Tick)
+	assert.Empty(t, frame.Names)
+}
+
+func TestReader_truncated(t *testing.T) {
+	data := record(t, 100)
+	rr, err := replay.NewReader(bytes.NewReader(data[:len(data)/2]))
+	if !assert.NoError(t, err) {
+		return
+	}
+	frames := readAll(t, rr)
+	assert.NotEmpty(t, frames)
+	assert.Less(t, len(frames), 100)
+}
+
+func TestReader_invalid(t *testing.T) {
+	data := record(t, 1)
+	future := bytes.Clone(data)
+	future[len("ASTRPLAY")+1] = 99
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", nil, replay.ErrFormat},
+		{"not a replay", []byte("PNG and then some more bytes"), replay.ErrFormat},
+		{"future version", future, replay.ErrVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := replay.NewReader(bytes.NewReader(tt.data))
+			assert.True(t, errors.Is(err, tt.expected), "error %v", err)
+		})
+	}
+}
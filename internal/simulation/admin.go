@@ -0,0 +1,313 @@
+package simulation
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"multiplayer/internal/admin"
+	"multiplayer/internal/state"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command struct {
+	cmd   admin.Command
+	reply chan commandResult
+}
+
+type commandResult struct {
+	data any
+	err  error
+}
+
+var usage = []string{
+	"help",
+	"rooms",
+	"list",
+	"kick ROOM ID|ADDR",
+	"ban IP|CIDR | ban ROOM ID|ADDR",
+	"unban IP|CIDR",
+	"allow IP|CIDR",
+	"disallow IP|CIDR",
+	"access",
+	"reload",
+	"say MESSAGE",
+	"tell ROOM MESSAGE",
+	"rules ROOM",
+	"set ROOM RULE VALUE",
+	"pause ROOM",
+	"resume ROOM",
+}
+
+type roomInfo struct {
+	Name       string `json:"name"`
+	Players    int    `json:"players"`
+	Capacity   int    `json:"capacity"`
+	Spectators int    `json:"spectators"`
+}
+
+type sessionInfo struct {
+	Room      string  `json:"room"`
+	ID        uint16  `json:"id"` // 0 for spectators
+	Spectator bool    `json:"spectator"`
+	Name      string  `json:"name,omitempty"`
+	Addr      string  `json:"addr"`
+	Ping      float64 `json:"ping_ms"`
+}
+
+type accessInfo struct {
+	Banned  []string `json:"banned"`
+	Allowed []string `json:"allowed"`
+}
+
+// access returns the addresses banned from and allowed on the server.
+func (sim *Simulation) access() accessInfo {
+	return accessInfo{Banned: sim.ln.Banned(), Allowed: sim.ln.Allowed()}
+}
+
+// Execute carries out cmd. Commands about a room take its name as their first
+// argument, and are carried out by the room at its next tick.
+func (sim *Simulation) Execute(ctx context.Context, cmd admin.Command) (any, error) {
+	switch cmd.Name {
+	case "help":
+		return usage, nil
+
+	case "rooms":
+		rooms := []roomInfo{}
+		sim.roomLock.Lock()
+		for _, rm := range sim.rooms {
+			rooms = append(rooms, roomInfo{
+				Name:       rm.name,
+				Players:    rm.players,
+				Capacity:   sim.roomCapacity,
+				Spectators: rm.spectators,
+			})
+		}
+		sim.roomLock.Unlock()
+		slices.SortFunc(rooms, func(a, b roomInfo) int { return cmp.Compare(a.Name, b.Name) })
+		return rooms, nil
+
+	case "list":
+		sessions := []sessionInfo{}
+		for _, rm := range sim.sortedRooms() {
+			data, err := rm.exec(ctx, cmd)
+			if err != nil {
+				return nil, fmt.Errorf("room %q: %w", rm.name, err)
+			}
+			sessions = append(sessions, data.([]sessionInfo)...)
+		}
+		slices.SortFunc(sessions, func(a, b sessionInfo) int {
+			return cmp.Or(cmp.Compare(a.Room, b.Room), cmp.Compare(a.ID, b.ID), cmp.Compare(a.Addr, b.Addr))
+		})
+		return sessions, nil
+
+	case "ban":
+		if len(cmd.Args) != 1 {
+			break // banning a player of a room
+		}
+		err := sim.ln.Ban(ctx, cmd.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return sim.access(), nil
+
+	case "unban", "allow", "disallow":
+		if len(cmd.Args) != 1 {
+			return nil, fmt.Errorf("usage: %s IP|CIDR", cmd.Name)
+		}
+		update := map[string]func(context.Context, string) error{
+			"unban":    sim.ln.Unban,
+			"allow":    sim.ln.Allow,
+			"disallow": sim.ln.Disallow,
+		}[cmd.Name]
+		err := update(ctx, cmd.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return sim.access(), nil
+
+	case "access":
+		return sim.access(), nil
+
+	case "reload":
+		err := sim.ln.Reload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return sim.access(), nil
+
+	case "say":
+		_, err := announcement(cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, rm := range sim.sortedRooms() {
+			_, err := rm.exec(ctx, admin.Command{Name: "tell", Args: cmd.Args})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("room %q: %w", rm.name, err))
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	if len(cmd.Args) == 0 {
+		return nil, fmt.Errorf("command %q: unknown command or missing room, try help", cmd.Name)
+	}
+	rm, err := sim.room(cmd.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	return rm.exec(ctx, admin.Command{Name: cmd.Name, Args: cmd.Args[1:]})
+}
+
+// exec has the room carry out cmd at its next tick.
+func (rm *room) exec(ctx context.Context, cmd admin.Command) (any, error) {
+	reply := make(chan commandResult, 1)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-rm.ctx.Done():
+		return nil, errors.New("room closed")
+	case rm.commandCh <- command{cmd: cmd, reply: reply}:
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-rm.ctx.Done():
+		return nil, errors.New("room closed")
+	case res := <-reply:
+		return res.data, res.err
+	}
+}
+
+// execute carries out cmd right away. It must only be called by tick.
+func (rm *room) execute(ctx context.Context, cmd admin.Command) (any, error) {
+	switch cmd.Name {
+	case "list":
+		sessions := []sessionInfo{}
+		rm.clientLock.Lock()
+		for addr, client := range rm.clients {
+			id, ok := rm.state.PlayerID(addr)
+			if !ok && !client.spectator {
+				continue
+			}
+			sessions = append(sessions, sessionInfo{
+				Room:      rm.name,
+				ID:        id,
+				Spectator: client.spectator,
+				Name:      client.name,
+				Addr:      addr,
+				Ping:      float64(client.sess.RTT()) / float64(time.Millisecond),
+			})
+		}
+		rm.clientLock.Unlock()
+		return sessions, nil
+
+	case "kick":
+		if len(cmd.Args) != 1 {
+			return nil, errors.New("usage: kick ROOM ID|ADDR")
+		}
+		addr, err := rm.clientAddr(cmd.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		rm.clientLock.Lock()
+		client, ok := rm.clients[addr]
+		rm.clientLock.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("player %s: not connected", cmd.Args[0])
+		}
+		// Spectators come and go unannounced.
+		if id, ok := rm.state.PlayerID(addr); ok {
+			rm.announce("%s was kicked", displayName(client.name, id))
+		}
+		return nil, client.sess.CloseWithReason(ctx, "kicked")
+
+	case "ban":
+		if len(cmd.Args) != 1 {
+			return nil, errors.New("usage: ban ROOM ID|ADDR")
+		}
+		addr, err := rm.clientAddr(cmd.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		err = rm.ln.Ban(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return accessInfo{Banned: rm.ln.Banned(), Allowed: rm.ln.Allowed()}, nil
+
+	case "tell":
+		text, err := announcement(cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+		rm.announce("%s", text)
+		return nil, nil
+
+	case "rules":
+		return rm.state.Rules, nil
+
+	case "set":
+		if len(cmd.Args) != 2 {
+			return nil, errors.New("usage: set ROOM RULE VALUE")
+		}
+		rules, err := rm.state.Rules.With(cmd.Args[0], cmd.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		rm.state.SetRules(rules)
+		rm.rulesChanged = true
+		return rules, nil
+
+	case "pause", "resume":
+		rm.state.Paused = cmd.Name == "pause"
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("command %q: unknown command, try help", cmd.Name)
+	}
+}
+
+// clientAddr returns the address of the client whose ID or address, as
+// listed by the list command, is s. Spectators have no ID but an address.
+func (rm *room) clientAddr(s string) (string, error) {
+	if _, _, err := net.SplitHostPort(s); err == nil {
+		rm.clientLock.Lock()
+		_, ok := rm.clients[s]
+		rm.clientLock.Unlock()
+		if !ok {
+			return "", fmt.Errorf("client %q: not found", s)
+		}
+		return s, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("player %q: not an ID or address", s)
+	}
+	addr, ok := rm.state.PlayerAddr(uint16(id))
+	if !ok {
+		return "", fmt.Errorf("player %d: not found", id)
+	}
+	return addr, nil
+}
+
+// announcement returns the words of a message of the operator as a line of
+// chat, which the server says in the rooms.
+func announcement(words []string) (string, error) {
+	msg := strings.Join(words, " ")
+	text := state.CleanChat(msg)
+	if len(text) == 0 || len(msg) > state.MaxChatLen {
+		return "", fmt.Errorf("message of %d bytes: must be 1 to %d bytes long", len(msg), state.MaxChatLen)
+	}
+	return text, nil
+}
@@ -0,0 +1,118 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"multiplayer/internal/mcp"
+	"multiplayer/internal/state"
+	"time"
+)
+
+// chatLine is something a client said, to be passed on to their room.
+type chatLine struct {
+	addr string
+	text string
+}
+
+const (
+	chatBurst    = 3
+	chatInterval = 2 * time.Second
+)
+
+// rateLimit lets a client do something burst times in a row, and once more
+// every interval after that.
+type rateLimit struct {
+	burst    float64
+	interval time.Duration
+
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimit(burst int, interval time.Duration) *rateLimit {
+	return &rateLimit{
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Time{},
+	}
+}
+
+func (l *rateLimit) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()/l.interval.Seconds())
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// chatMessage encodes c for the clients.
+func chatMessage(c state.Chat) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 3+len(c.Name)+len(c.Text)))
+	_ = binary.Write(buf, binary.BigEndian, uint16(5) /* type = chat */)
+	c.Encode(buf)
+	return buf.Bytes()
+}
+
+// displayName returns how the chat refers to the player called name with id.
+func displayName(name string, id uint16) string {
+	if len(name) > 0 {
+		return name
+	}
+	return fmt.Sprintf("Player %d", id)
+}
+
+// announce has the server say text in the chat of the room at the end of the
+// tick. It must only be called by tick.
+func (rm *room) announce(format string, args ...any) {
+	rm.chats = append(rm.chats, state.Chat{Name: "", Text: fmt.Sprintf(format, args...)})
+}
+
+// sendChats passes on what was said since the last tick to everyone in the
+// room.
+func (rm *room) sendChats(ctx context.Context) error {
+CHAT_LOOP:
+	for {
+		select {
+		case line := <-rm.chatCh:
+			rm.clientLock.Lock()
+			c, ok := rm.clients[line.addr]
+			rm.clientLock.Unlock()
+			if !ok {
+				continue
+			}
+			id, ok := rm.state.PlayerID(line.addr)
+			name := c.name
+			if !ok && len(name) == 0 {
+				name = "Spectator"
+			}
+			rm.chats = append(rm.chats, state.Chat{Name: displayName(name, id), Text: line.text})
+		default:
+			break CHAT_LOOP
+		}
+	}
+
+	chats := rm.chats
+	rm.chats = rm.chats[:0]
+	if len(chats) == 0 {
+		return nil
+	}
+	sessions := rm.sessions()
+	for _, c := range chats {
+		err := rm.ln.Multicast(ctx, sessions, chatMessage(c))
+		if errors.Is(err, mcp.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			rm.logger.Warn("failed to send chat", "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"multiplayer/internal/leaderboard"
+	"multiplayer/internal/state"
+	"time"
+)
+
+// maxTop is the most entries of the leaderboard a client may ask for.
+const maxTop = 20
+
+const (
+	queryBurst    = 2
+	queryInterval = time.Second
+)
+
+// matchBacklog is how many finished matches may wait to be recorded into the
+// leaderboard before rooms drop the ones they finish.
+const matchBacklog = 16
+
+// leaderboardMessage encodes the n best scores of board for a client, none if
+// there is no board.
+func leaderboardMessage(board *leaderboard.Board, n int) []byte {
+	var top []leaderboard.Entry
+	if board != nil {
+		top = board.Top(min(n, maxTop))
+	}
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(6) /* type = leaderboard */)
+	leaderboard.Encode(&buf, top)
+	return buf.Bytes()
+}
+
+// recordMatch hands the game the room just finished, or stops in the middle
+// of, to be recorded into the leaderboard along with the players who left
+// before it was over. It must only be called by tick or as the room stops.
+func (rm *room) recordMatch() {
+	if rm.matches == nil {
+		return
+	}
+	m := leaderboard.Match{
+		Room:       rm.name,
+		Mode:       rm.state.Mode.String(),
+		Ended:      time.Now(),
+		Wave:       rm.state.Wave,
+		TotalScore: rm.state.TotalScore,
+		Players:    rm.gone,
+	}
+	rm.gone = nil
+	for _, player := range rm.state.Players {
+		m.Players = append(m.Players, rm.result(player))
+	}
+	select {
+	case rm.matches <- m:
+	default:
+		rm.logger.Warn("dropped match as the leaderboard is falling behind")
+	}
+}
+
+// recordUnfinished records the match the room is in the middle of as it
+// stops, whether as the last client leaves or as the server shuts down, so
+// that the scores made so far are kept.
+func (rm *room) recordUnfinished() {
+	if rm.state.Phase == state.PhaseGameOver || rm.state.TotalScore == 0 && len(rm.gone) == 0 {
+		return
+	}
+	rm.recordMatch()
+}
+
+// keepResult keeps how the player with id did so far in the match, which they
+// are leaving before it is over. It must only be called by tick, before the
+// player is removed.
+func (rm *room) keepResult(id uint16) {
+	if rm.matches == nil || rm.state.Phase == state.PhaseGameOver {
+		return
+	}
+	for _, player := range rm.state.Players {
+		if player.ID == id {
+			rm.gone = append(rm.gone, rm.result(player))
+		}
+	}
+}
+
+// result returns how player did in the match.
+func (rm *room) result(player state.Player) leaderboard.Result {
+	// Pilots take part in matches but not in the ranking.
+	name := rm.snapshotNames[player.ID]
+	if addr, ok := rm.state.PlayerAddr(player.ID); ok && rm.isPilot(addr) {
+		name = ""
+	}
+	return leaderboard.Result{
+		Name:   name,
+		Score:  player.Score,
+		Kills:  player.Kills,
+		Deaths: player.Deaths,
+	}
+}
+
+// roomMatches returns where rooms hand the matches they finish, or nil if
+// the simulation keeps no leaderboard.
+func (sim *Simulation) roomMatches() chan<- leaderboard.Match {
+	if sim.board == nil {
+		return nil
+	}
+	return sim.matches
+}
+
+// recordLoop records the matches handed over by rooms into the leaderboard,
+// which is saved to disk every time, away from their ticks. Once closing is
+// closed, it records the matches left waiting and returns.
+func (sim *Simulation) recordLoop() {
+	defer close(sim.recorded)
+	for {
+		select {
+		case m := <-sim.matches:
+			sim.record(m)
+		case <-sim.closing:
+			for {
+				select {
+				case m := <-sim.matches:
+					sim.record(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (sim *Simulation) record(m leaderboard.Match) {
+	err := sim.board.Record(m)
+	if err != nil {
+		slog.Warn("failed to record match into leaderboard", "room", m.Room, "error", err)
+	}
+}
@@ -0,0 +1,78 @@
+package simulation
+
+import (
+	"multiplayer/internal/mcp"
+	"multiplayer/internal/metrics"
+	"multiplayer/internal/state"
+	"net/http"
+)
+
+type simMetrics struct {
+	registry *metrics.Registry
+
+	tickSeconds         *metrics.Histogram
+	snapshotBytes       *metrics.Histogram
+	snapshotDropped     *metrics.Counter
+	entities            *metrics.GaugeVec
+	inputDecodeFailures *metrics.Counter
+}
+
+var entityTypes = [...]string{"player", "bullet", "asteroid", "power_up", "ufo"}
+
+func newSimMetrics(ln *mcp.Listener, numRooms func() int) simMetrics {
+	r := &metrics.Registry{}
+	m := simMetrics{
+		registry: r,
+		tickSeconds: r.Histogram("asteroids_tick_duration_seconds", "Time taken by a tick of the simulation.",
+			[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05}),
+		snapshotBytes: r.Histogram("asteroids_snapshot_size_bytes", "Size of the encoded state broadcast every tick.",
+			[]float64{128, 256, 512, 1024, 2048, 4096}),
+		snapshotDropped: r.Counter("asteroids_snapshot_dropped_entities_total",
+			"Entities left out of snapshots so that they fit into a datagram."),
+		entities:            r.GaugeVec("asteroids_entities", "Entities in the game by room and type.", "room", "type"),
+		inputDecodeFailures: r.Counter("asteroids_input_decode_failures_total", "Inputs from clients which could not be decoded."),
+	}
+
+	r.GaugeFunc("asteroids_rooms", "Open rooms.", func() float64 { return float64(numRooms()) })
+
+	stat := func(f func(mcp.Stats) uint64) func() uint64 {
+		return func() uint64 { return f(ln.Stats()) }
+	}
+	r.GaugeFunc("mcp_sessions", "Open sessions.", func() float64 { return float64(ln.NumSessions()) })
+	r.CounterFunc("mcp_received_bytes_total", "Bytes received.", stat(func(st mcp.Stats) uint64 { return st.BytesIn }))
+	r.CounterFunc("mcp_sent_bytes_total", "Bytes sent.", stat(func(st mcp.Stats) uint64 { return st.BytesOut }))
+	r.CounterFunc("mcp_received_datagrams_total", "Datagrams received.", stat(func(st mcp.Stats) uint64 { return st.DatagramsIn }))
+	r.CounterFunc("mcp_sent_datagrams_total", "Datagrams sent.", stat(func(st mcp.Stats) uint64 { return st.DatagramsOut }))
+	r.CounterFunc("mcp_inbox_dropped_total", "Messages dropped as sessions did not receive them in time.",
+		stat(func(st mcp.Stats) uint64 { return st.InboxDropped }))
+	r.CounterFunc("mcp_outbox_dropped_total", "Messages dropped as the listener did not send them in time.",
+		stat(func(st mcp.Stats) uint64 { return st.OutboxDropped }))
+	r.CounterFunc("mcp_decode_failures_total", "Datagrams which could not be decoded.",
+		stat(func(st mcp.Stats) uint64 { return st.DecodeFailures }))
+	r.CounterFunc("mcp_join_rejections_total", "Attempts to join which were refused.",
+		stat(func(st mcp.Stats) uint64 { return st.JoinRejections }))
+	r.CounterFunc("mcp_denied_datagrams_total", "Datagrams dropped as they came from a banned or unallowed address.",
+		stat(func(st mcp.Stats) uint64 { return st.Denied }))
+	return m
+}
+
+// observeEntities counts the entities of s, the state of room, by type.
+func (m simMetrics) observeEntities(room string, s state.State) {
+	counts := [len(entityTypes)]int{len(s.Players), len(s.Bullets), len(s.Asteroids), len(s.PowerUps), len(s.UFOs)}
+	for i, typ := range entityTypes {
+		m.entities.With(room, typ).Set(float64(counts[i]))
+	}
+}
+
+// forgetRoom drops the metrics of a closed room.
+func (m simMetrics) forgetRoom(room string) {
+	for _, typ := range entityTypes {
+		m.entities.Delete(room, typ)
+	}
+}
+
+// Metrics serves the metrics of the simulation and its listener in the
+// Prometheus text format.
+func (sim *Simulation) Metrics() http.Handler {
+	return sim.metrics.registry
+}
@@ -0,0 +1,45 @@
+package simulation
+
+import (
+	"fmt"
+	"slices"
+)
+
+// pilot is an AI player, steered by ai.Steer.
+type pilot struct {
+	addr string // made up, as a key of the player in the state
+	name string
+}
+
+// fillPilots has pilots join or leave so that the room has at least
+// minPlayers players, counting only as many pilots as needed. It must only be
+// called by tick.
+func (rm *room) fillPilots() {
+	humans := len(rm.state.Players) - len(rm.pilots)
+	want := max(0, rm.minPlayers-humans)
+	for len(rm.pilots) < want {
+		rm.lastPilot++
+		rm.clientLock.Lock()
+		p := pilot{
+			addr: fmt.Sprintf("ai:%d", rm.lastPilot),
+			name: uniqueName(fmt.Sprintf("AI %d", rm.lastPilot), rm.takenNames()),
+		}
+		rm.pilots = append(rm.pilots, p)
+		rm.clientLock.Unlock()
+		rm.state.AddPlayer(p.addr)
+		rm.logger.Info("pilot joined", "name", p.name)
+	}
+	for len(rm.pilots) > want {
+		p := rm.pilots[len(rm.pilots)-1]
+		rm.state.RemovePlayer(p.addr)
+		rm.clientLock.Lock()
+		rm.pilots = rm.pilots[:len(rm.pilots)-1]
+		rm.clientLock.Unlock()
+		rm.logger.Info("pilot left", "name", p.name)
+	}
+}
+
+// isPilot reports whether the player at addr is a pilot.
+func (rm *room) isPilot(addr string) bool {
+	return slices.ContainsFunc(rm.pilots, func(p pilot) bool { return p.addr == addr })
+}
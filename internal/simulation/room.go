@@ -0,0 +1,463 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"maps"
+	"multiplayer/internal/ai"
+	"multiplayer/internal/leaderboard"
+	"multiplayer/internal/mcp"
+	"multiplayer/internal/replay"
+	"multiplayer/internal/state"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateHeaderSize is the size of what comes before the snapshot in a state
+// message: its type, index and checksum.
+const stateHeaderSize = 2 + 4 + 4
+
+// room is a game of its own, ticking TPS times a second until it is stopped.
+type room struct {
+	name    string
+	ln      *mcp.Listener
+	metrics simMetrics
+	logger  *slog.Logger
+
+	// players and spectators count the clients who were admitted to the room
+	// and have not left yet. They are guarded by Simulation.roomLock.
+	players    int
+	spectators int
+
+	clients        map[string]client
+	clientLock     sync.Mutex
+	state          state.State
+	lastStateIndex uint32
+	ticks          uint32
+
+	// recorder records every snapshot if the simulation records replays.
+	recorder *replay.Writer
+	// pilots are the AI players filling the room up to minPlayers players,
+	// the latest to join last. They are only changed by tick, with clientLock
+	// held so that join can tell their names apart.
+	pilots     []pilot
+	minPlayers int
+	lastPilot  int // number of the latest pilot to join
+
+	// matches takes every match played until it is over to be recorded into
+	// the leaderboard, if the simulation keeps one. gone holds the results of
+	// the players who left the match under way. phase is that of the state at
+	// the previous tick.
+	matches chan<- leaderboard.Match
+	gone    []leaderboard.Result
+	phase   state.Phase
+
+	remoteJoinedAddrCh chan string
+	remoteLeftAddrCh   chan string
+	commandCh          chan command
+	chatCh             chan chatLine
+
+	// chats is what was said during the tick, including by the server.
+	chats []state.Chat
+
+	// rulesChanged is set by the set command to hand the new rules to
+	// clients right away.
+	rulesChanged bool
+
+	// snapshot is the latest state as encoded for the clients, kept for the
+	// window of the server along with the rules needed to decode it and the
+	// names of the players. namesVersion counts the changes to the names, so
+	// that clients can tell when theirs are out of date.
+	snapshot      []byte
+	snapshotRules state.Rules
+	snapshotNames state.Names
+	namesVersion  uint32
+	snapshotLock  sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // closed as run returns
+}
+
+func (sim *Simulation) newRoom(name string) *room {
+	st := state.Init()
+	st.SetRules(sim.rules)
+	st.World.Wrap = sim.wrap
+	st.Mode = sim.mode
+	st.FriendlyFire = sim.friendlyFire
+
+	var recorder *replay.Writer
+	if len(sim.recordDir) > 0 {
+		path := filepath.Join(sim.recordDir, fmt.Sprintf("%s-%s.replay", name, time.Now().Format("20060102-150405")))
+		var err error
+		recorder, err = replay.Create(path, TPS)
+		if err != nil {
+			slog.Warn("failed to start recording room", "room", name, "error", err)
+		} else {
+			slog.Info("recording room", "room", name, "path", path)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &room{
+		name:               name,
+		ln:                 sim.ln,
+		metrics:            sim.metrics,
+		logger:             slog.With("room", name),
+		players:            0,
+		spectators:         0,
+		clients:            map[string]client{},
+		clientLock:         sync.Mutex{},
+		state:              st,
+		lastStateIndex:     0,
+		ticks:              0,
+		recorder:           recorder,
+		pilots:             nil,
+		minPlayers:         sim.minPlayers,
+		lastPilot:          0,
+		matches:            sim.roomMatches(),
+		gone:               nil,
+		phase:              st.Phase,
+		remoteJoinedAddrCh: make(chan string, 10),
+		remoteLeftAddrCh:   make(chan string, 10),
+		commandCh:          make(chan command, 10),
+		chatCh:             make(chan chatLine, 10),
+		chats:              nil,
+		rulesChanged:       false,
+		snapshot:           nil,
+		snapshotRules:      st.Rules,
+		snapshotNames:      state.Names{},
+		namesVersion:       0,
+		snapshotLock:       sync.Mutex{},
+		ctx:                ctx,
+		cancel:             cancel,
+		done:               make(chan struct{}),
+	}
+}
+
+// enter takes a place in the room. It must be called with
+// Simulation.roomLock held.
+func (rm *room) enter(spectator bool) {
+	if spectator {
+		rm.spectators++
+	} else {
+		rm.players++
+	}
+}
+
+// join adds the client c at addr to the room, with a ship of their own unless
+// they are a spectator. It returns c with a name no one else in the room goes
+// by, pilots included, numbering it if it is taken.
+func (rm *room) join(addr string, c client) client {
+	rm.clientLock.Lock()
+	if len(c.name) > 0 {
+		c.name = uniqueName(c.name, rm.takenNames())
+	}
+	rm.clients[addr] = c
+	rm.clientLock.Unlock()
+	select {
+	case rm.remoteJoinedAddrCh <- addr:
+	case <-rm.ctx.Done():
+	}
+	return c
+}
+
+func (rm *room) leave(addr string) {
+	rm.clientLock.Lock()
+	c := rm.clients[addr]
+	delete(rm.clients, addr)
+	rm.clientLock.Unlock()
+	if c.spectator {
+		return
+	}
+	select {
+	case rm.remoteLeftAddrCh <- addr:
+	case <-rm.ctx.Done():
+	}
+}
+
+func (rm *room) stop() {
+	rm.cancel()
+}
+
+// view returns a copy of the latest state of the room, along with the names of
+// its players.
+func (rm *room) view() (state.State, state.Names, bool) {
+	rm.snapshotLock.Lock()
+	snapshot, rules, names := rm.snapshot, rm.snapshotRules, rm.snapshotNames
+	rm.snapshotLock.Unlock()
+	if snapshot == nil {
+		return state.State{}, nil, false
+	}
+
+	var s state.State
+	s.SetRules(rules)
+	err := s.Decode(bytes.NewReader(snapshot))
+	if err != nil {
+		rm.logger.Warn("failed to decode snapshot", "error", err)
+		return state.State{}, nil, false
+	}
+	return s, names, true
+}
+
+func (rm *room) run() {
+	defer close(rm.done)
+	defer rm.stopRecording()
+	defer rm.recordUnfinished()
+	ticker := time.NewTicker(time.Second / TPS)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := rm.tick()
+		if errors.Is(err, mcp.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (rm *room) tick() error {
+	const dt = time.Second / TPS
+	start := time.Now()
+	defer func() { rm.metrics.tickSeconds.Observe(time.Since(start).Seconds()) }()
+
+	ctx, cancel := context.WithTimeout(rm.ctx, dt)
+	defer cancel()
+
+	joined := false
+ADD_PLAYER_LOOP:
+	for {
+		select {
+		case addr := <-rm.remoteJoinedAddrCh:
+			rm.clientLock.Lock()
+			c, ok := rm.clients[addr]
+			rm.clientLock.Unlock()
+			if ok && !c.spectator {
+				rm.state.AddPlayer(addr)
+				id, _ := rm.state.PlayerID(addr)
+				rm.announce("%s joined", displayName(c.name, id))
+			}
+			joined = true
+		default:
+			break ADD_PLAYER_LOOP
+		}
+	}
+REMOVE_PLAYER_LOOP:
+	for {
+		select {
+		case addr := <-rm.remoteLeftAddrCh:
+			if id, ok := rm.state.PlayerID(addr); ok {
+				rm.announce("%s left", displayName(rm.snapshotNames[id], id))
+				rm.keepResult(id)
+			}
+			rm.state.RemovePlayer(addr)
+		default:
+			break REMOVE_PLAYER_LOOP
+		}
+	}
+
+	rm.fillPilots()
+
+COMMAND_LOOP:
+	for {
+		select {
+		case c := <-rm.commandCh:
+			data, err := rm.execute(ctx, c.cmd)
+			c.reply <- commandResult{data: data, err: err}
+		default:
+			break COMMAND_LOOP
+		}
+	}
+
+	inputs := map[string]state.Input{}
+	rm.clientLock.Lock()
+	for addr, client := range rm.clients {
+		select {
+		case input := <-client.inputc:
+			inputs[addr] = input
+		default:
+		}
+	}
+	rm.clientLock.Unlock()
+	for _, p := range rm.pilots {
+		if id, ok := rm.state.PlayerID(p.addr); ok {
+			inputs[p.addr] = ai.Steer(rm.state, id)
+		}
+	}
+
+	rm.state.Update(dt, inputs)
+	rm.metrics.observeEntities(rm.name, rm.state)
+	if rm.state.Phase == state.PhaseGameOver && rm.phase != state.PhaseGameOver {
+		rm.recordMatch()
+	}
+	rm.phase = rm.state.Phase
+
+	// The names of the players are sent to every client as they change,
+	// rather than in every state.
+	names := rm.names()
+	if !maps.Equal(names, rm.snapshotNames) {
+		rm.snapshotLock.Lock()
+		rm.snapshotNames = names
+		rm.namesVersion++
+		rm.snapshotLock.Unlock()
+		namesBuf := rm.namesMessage()
+		rm.clientLock.Lock()
+		for _, client := range rm.clients {
+			_ = client.sess.TrySend(namesBuf)
+		}
+		rm.clientLock.Unlock()
+	}
+
+	// Clients need to know which player they are and the rules before making
+	// sense of any state. The welcome is sent as soon as someone joins, and
+	// repeated every second for those whose copy got lost on the way.
+	// Spectators are told they are player 0, which no player ever is. The
+	// version of the names goes along, for clients who missed a change of
+	// names or joined after it to ask for them.
+	if joined || rm.rulesChanged || rm.lastStateIndex%TPS == 0 {
+		rm.rulesChanged = false
+		rm.clientLock.Lock()
+		for addr, client := range rm.clients {
+			id, ok := rm.state.PlayerID(addr)
+			if !ok && !client.spectator {
+				continue
+			}
+			welcomeBuf := bytes.NewBuffer(make([]byte, 0, 4))
+			_ = binary.Write(welcomeBuf, binary.BigEndian, uint16(2) /* type = welcome */)
+			_ = binary.Write(welcomeBuf, binary.BigEndian, id)
+			rm.state.Rules.Encode(welcomeBuf)
+			_ = binary.Write(welcomeBuf, binary.BigEndian, rm.namesVersion)
+			_ = client.sess.TrySend(welcomeBuf.Bytes())
+		}
+		rm.clientLock.Unlock()
+	}
+
+	// Snapshots too large for a datagram would be cut short on the way, so
+	// they leave out as many entities as it takes to fit. The state of the
+	// room keeps them all.
+	fitted, dropped := rm.state.Fit(rm.ln.DataSize() - stateHeaderSize)
+	if dropped > 0 {
+		rm.metrics.snapshotDropped.Add(uint64(dropped))
+	}
+	var snapshot bytes.Buffer
+	fitted.Encode(&snapshot)
+	rm.snapshotLock.Lock()
+	rm.snapshot = snapshot.Bytes()
+	rm.snapshotRules = rm.state.Rules
+	rm.snapshotLock.Unlock()
+	if rm.recorder != nil {
+		err := rm.recorder.Write(rm.ticks, rm.state.Rules, rm.snapshotNames, snapshot.Bytes())
+		if err != nil {
+			rm.logger.Warn("failed to record snapshot", "error", err)
+			rm.stopRecording()
+		}
+	}
+	rm.ticks++
+
+	err := rm.sendChats(ctx)
+	if err != nil {
+		return err
+	}
+
+	stateBuf := bytes.NewBuffer(make([]byte, 0, stateHeaderSize+snapshot.Len()))
+	_ = binary.Write(stateBuf, binary.BigEndian, uint16(1) /* type = state */)
+	_ = binary.Write(stateBuf, binary.BigEndian, rm.lastStateIndex)
+	// The same as fitted.Checksum, without encoding it all over again.
+	_ = binary.Write(stateBuf, binary.BigEndian, crc32.ChecksumIEEE(snapshot.Bytes()))
+	_, _ = stateBuf.Write(snapshot.Bytes())
+	rm.metrics.snapshotBytes.Observe(float64(stateBuf.Len()))
+	err = rm.ln.Multicast(ctx, rm.sessions(), stateBuf.Bytes())
+	if errors.Is(err, mcp.ErrClosed) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err != nil {
+		rm.logger.Warn("failed to send state", "error", err)
+		return nil
+	}
+	rm.lastStateIndex++
+
+	return nil
+}
+
+func (rm *room) stopRecording() {
+	if rm.recorder == nil {
+		return
+	}
+	err := rm.recorder.Close()
+	if err != nil {
+		rm.logger.Warn("failed to finish recording", "error", err)
+	}
+	rm.recorder = nil
+}
+
+// names returns the names of the players in the room who picked one, and of
+// the pilots. It must only be called by tick.
+func (rm *room) names() state.Names {
+	rm.clientLock.Lock()
+	defer rm.clientLock.Unlock()
+	names := state.Names{}
+	for _, p := range rm.pilots {
+		if id, ok := rm.state.PlayerID(p.addr); ok {
+			names[id] = p.name
+		}
+	}
+	for addr, client := range rm.clients {
+		id, ok := rm.state.PlayerID(addr)
+		if ok && len(client.name) > 0 {
+			names[id] = client.name
+		}
+	}
+	return names
+}
+
+// namesMessage encodes the latest names of the players for a client, along
+// with their version.
+func (rm *room) namesMessage() []byte {
+	rm.snapshotLock.Lock()
+	defer rm.snapshotLock.Unlock()
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint16(4) /* type = names */)
+	_ = binary.Write(&buf, binary.BigEndian, rm.namesVersion)
+	rm.snapshotNames.Encode(&buf)
+	return buf.Bytes()
+}
+
+// takenNames returns the names of the clients and pilots in the room. It must
+// be called with clientLock held.
+func (rm *room) takenNames() map[string]bool {
+	taken := map[string]bool{}
+	for _, c := range rm.clients {
+		if len(c.name) > 0 {
+			taken[c.name] = true
+		}
+	}
+	for _, p := range rm.pilots {
+		taken[p.name] = true
+	}
+	return taken
+}
+
+// sessions returns the sessions of the clients in the room.
+func (rm *room) sessions() []*mcp.Session {
+	rm.clientLock.Lock()
+	defer rm.clientLock.Unlock()
+	sessions := make([]*mcp.Session, 0, len(rm.clients))
+	for _, client := range rm.clients {
+		sessions = append(sessions, client.sess)
+	}
+	return sessions
+}
@@ -2,60 +2,280 @@ package simulation
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
-	"multiplayer/assets"
 	"multiplayer/internal/jitter"
+	"multiplayer/internal/leaderboard"
 	"multiplayer/internal/mcp"
+	"multiplayer/internal/metrics"
+	"multiplayer/internal/render"
 	"multiplayer/internal/state"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 )
 
+// TPS is how many times a second every room ticks.
+const TPS = 30
+
+// Simulation hosts any number of rooms behind a single listener. Each room is
+// a game of its own, ticking on its own, and is created as the first client
+// asks for it and dropped as the last one leaves.
 type Simulation struct {
-	ln             *mcp.Listener
-	clients        map[string]client
-	clientLock     sync.Mutex
-	state          state.State
-	lastStateIndex uint32
+	options
+	ln      *mcp.Listener
+	metrics simMetrics
+	board   *leaderboard.Board // nil without a leaderboard
+
+	// matches are finished by rooms and wait to be recorded by recordLoop,
+	// which closes recorded once closing is closed and they are all in.
+	matches  chan leaderboard.Match
+	closing  chan struct{}
+	recorded chan struct{}
+
+	rooms    map[string]*room
+	roomLock sync.Mutex
+	lastRoom int // number of the latest room named automatically
+}
+
+type Option func(opts *options) error
+
+type options struct {
+	wrap              bool
+	mode              state.Mode
+	friendlyFire      bool
+	rules             state.Rules
+	roomCapacity      int
+	spectatorCapacity int
+	maxRooms          int
+	recordDir         string
+	leaderboardPath   string
+	minPlayers        int
+	password          string
+	accessPath        string
+}
+
+// WithWrap makes entities wrap around to the opposite edge of the world rather
+// than being stopped or removed at its edges.
+func WithWrap(wrap bool) Option {
+	return func(opts *options) error {
+		opts.wrap = wrap
+		return nil
+	}
+}
+
+// WithMode sets the game mode, which is announced to clients through every
+// state.
+func WithMode(mode state.Mode) Option {
+	return func(opts *options) error {
+		if mode > state.ModeTeamDeathmatch {
+			return fmt.Errorf("mode %d: unknown mode", mode)
+		}
+		opts.mode = mode
+		return nil
+	}
+}
+
+// WithFriendlyFire lets teammates hurt each other in
+// state.ModeTeamDeathmatch.
+func WithFriendlyFire(friendlyFire bool) Option {
+	return func(opts *options) error {
+		opts.friendlyFire = friendlyFire
+		return nil
+	}
+}
+
+// WithRules sets the tunables of the game, which are handed to clients as they
+// join.
+func WithRules(rules state.Rules) Option {
+	return func(opts *options) error {
+		err := rules.Validate()
+		if err != nil {
+			return fmt.Errorf("rules: %w", err)
+		}
+		opts.rules = rules
+		return nil
+	}
+}
+
+// WithRoomCapacity sets how many players fit in a room.
+func WithRoomCapacity(capacity int) Option {
+	return func(opts *options) error {
+		if capacity < 1 {
+			return fmt.Errorf("room capacity %d: not a positive number", capacity)
+		}
+		opts.roomCapacity = capacity
+		return nil
+	}
+}
+
+// WithSpectatorCapacity sets how many spectators may watch a room, on top of
+// its players.
+func WithSpectatorCapacity(capacity int) Option {
+	return func(opts *options) error {
+		if capacity < 0 {
+			return fmt.Errorf("spectator capacity %d: negative number", capacity)
+		}
+		opts.spectatorCapacity = capacity
+		return nil
+	}
+}
+
+// WithMaxRooms sets how many rooms may be open at once.
+func WithMaxRooms(maxRooms int) Option {
+	return func(opts *options) error {
+		if maxRooms < 1 {
+			return fmt.Errorf("max rooms %d: not a positive number", maxRooms)
+		}
+		opts.maxRooms = maxRooms
+		return nil
+	}
+}
 
-	remoteJoinedAddrCh chan string
-	remoteLeftAddrCh   chan string
+// WithRecordDir records a replay of every room into a file of its own within
+// dir, named after the room and the time it opened.
+func WithRecordDir(dir string) Option {
+	return func(opts *options) error {
+		opts.recordDir = dir
+		return nil
+	}
 }
 
-func Start(laddr string) (*Simulation, error) {
-	ln, err := mcp.Listen(laddr, mcp.WithLogger(slog.Default()))
+// WithMinPlayers has AI pilots fill every room up to minPlayers players,
+// leaving one by one as people join.
+func WithMinPlayers(minPlayers int) Option {
+	return func(opts *options) error {
+		if minPlayers < 0 {
+			return fmt.Errorf("min players %d: negative number", minPlayers)
+		}
+		opts.minPlayers = minPlayers
+		return nil
+	}
+}
+
+// WithPassword only lets in clients who know password; see mcp.WithPassword.
+func WithPassword(password string) Option {
+	return func(opts *options) error {
+		opts.password = password
+		return nil
+	}
+}
+
+// WithAccessFile keeps the banned and allowed addresses in the file at path;
+// see mcp.WithAccessFile.
+func WithAccessFile(path string) Option {
+	return func(opts *options) error {
+		opts.accessPath = path
+		return nil
+	}
+}
+
+// WithLeaderboard keeps the best scores of the players with a name and the
+// results of the latest matches in the file at path, which clients may query.
+func WithLeaderboard(path string) Option {
+	return func(opts *options) error {
+		opts.leaderboardPath = path
+		return nil
+	}
+}
+
+func Start(laddr string, opts ...Option) (*Simulation, error) {
+	o := options{
+		wrap:              false,
+		mode:              state.ModeCoop,
+		friendlyFire:      false,
+		rules:             state.DefaultRules(),
+		roomCapacity:      8,
+		spectatorCapacity: 16,
+		maxRooms:          16,
+		recordDir:         "",
+		leaderboardPath:   "",
+		minPlayers:        0,
+		password:          "",
+		accessPath:        "",
+	}
+	var optErrs []error
+	for _, opt := range opts {
+		optErrs = append(optErrs, opt(&o))
+	}
+	if err := errors.Join(optErrs...); err != nil {
+		return nil, err
+	}
+
+	var board *leaderboard.Board
+	if len(o.leaderboardPath) > 0 {
+		var err error
+		board, err = leaderboard.Open(o.leaderboardPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := mcp.Listen(laddr,
+		mcp.WithLogger(slog.Default()),
+		mcp.WithPassword(o.password),
+		mcp.WithAccessFile(o.accessPath))
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("bound udp/mcp listener", "address", ln.LocalAddr())
+	slog.Info("bound udp/mcp listener", "address", ln.LocalAddr(), "mode", o.mode)
+	if len(o.recordDir) > 0 {
+		err = os.MkdirAll(o.recordDir, 0o755)
+		if err != nil {
+			return nil, errors.Join(err, ln.Close(context.Background()))
+		}
+	}
 
 	sim := &Simulation{
-		ln:                 ln,
-		clients:            map[string]client{},
-		clientLock:         sync.Mutex{},
-		state:              state.Init(),
-		lastStateIndex:     0,
-		remoteJoinedAddrCh: make(chan string, 10),
-		remoteLeftAddrCh:   make(chan string, 10),
+		options:  o,
+		ln:       ln,
+		metrics:  simMetrics{},
+		board:    board,
+		matches:  make(chan leaderboard.Match, matchBacklog),
+		closing:  make(chan struct{}),
+		recorded: make(chan struct{}),
+		rooms:    map[string]*room{},
+		roomLock: sync.Mutex{},
+		lastRoom: 0,
 	}
+	sim.metrics = newSimMetrics(ln, sim.numRooms)
 	go sim.acceptLoop(context.Background())
+	go sim.recordLoop()
 	return sim, nil
 }
 
 type client struct {
-	sess   *mcp.Session
-	inputc chan state.Input
+	sess      *mcp.Session
+	inputc    chan state.Input
+	spectator bool
+	name      string // unique within the room, or empty
+	chatc     chan<- chatLine
+	board     *leaderboard.Board // nil without a leaderboard
+	names     func() []byte      // encodes the latest names of the room
+
+	decodeFailures *metrics.Counter
 }
 
+// receiveLoop passes on what the client sends, which is either their latest
+// inputs or a line of chat, and answers their queries of the leaderboard and
+// of the names of the players.
 func (c client) receiveLoop(ctx context.Context) {
-	logger := slog.With("remote", c.sess.RemoteAddr())
+	addr := c.sess.RemoteAddr().String()
+	logger := slog.With("remote", addr)
+	chats := newRateLimit(chatBurst, chatInterval)
+	queries := newRateLimit(queryBurst, queryInterval)
 
 	for {
 		data, err := c.sess.Receive(ctx)
@@ -67,9 +287,55 @@ func (c client) receiveLoop(ctx context.Context) {
 			continue
 		}
 
+		r := bytes.NewReader(data)
+		var typ uint16
+		err = binary.Read(r, binary.BigEndian, &typ)
+		if err != nil {
+			c.decodeFailures.Inc()
+			logger.Warn("failed to read message type", "error", err)
+			continue
+		}
+		switch typ {
+		case 1: // chat
+			if !chats.allow(time.Now()) {
+				_ = c.sess.TrySend(chatMessage(state.Chat{Name: "", Text: "You are chatting too fast."}))
+				continue
+			}
+			text := state.CleanChat(string(data[len(data)-r.Len():]))
+			if len(text) == 0 {
+				continue
+			}
+			select {
+			case c.chatc <- chatLine{addr: addr, text: text}:
+			default:
+			}
+			continue
+
+		case 2: // leaderboard query
+			if !queries.allow(time.Now()) {
+				continue
+			}
+			n, err := r.ReadByte()
+			if err != nil {
+				c.decodeFailures.Inc()
+				logger.Warn("failed to read leaderboard query", "error", err)
+				continue
+			}
+			_ = c.sess.TrySend(leaderboardMessage(c.board, int(n)))
+			continue
+
+		case 3: // names query
+			if !queries.allow(time.Now()) {
+				continue
+			}
+			_ = c.sess.TrySend(c.names())
+			continue
+		}
+
 		var buf jitter.Buffer
-		err = buf.Decode(bytes.NewReader(data))
+		err = buf.Decode(r)
 		if err != nil {
+			c.decodeFailures.Inc()
 			logger.Warn("failed to unmarshal inputs", "error", err)
 			continue
 		}
@@ -101,146 +367,255 @@ func (sim *Simulation) acceptLoop(ctx context.Context) {
 			slog.Warn("failed to accept session", "error", err)
 			continue
 		}
+		raddr := sess.RemoteAddr().String()
+
+		var join state.Join
+		err = join.Decode(bytes.NewReader(sess.JoinData()))
+		if err == nil {
+			join.Name, err = validateName(join.Name)
+		}
+		var rm *room
+		if err == nil {
+			rm, err = sim.admit(join)
+		}
+		if err != nil {
+			slog.Warn("refused client", "raddr", raddr, "error", err)
+			err = sess.Refuse(ctx, err.Error())
+			if err != nil {
+				slog.Warn("failed to close refused session", "raddr", raddr, "error", err)
+			}
+			continue
+		}
 
 		c := client{
-			sess:   sess,
-			inputc: make(chan state.Input, 1),
+			sess:           sess,
+			inputc:         make(chan state.Input, 1),
+			spectator:      join.Spectate,
+			name:           join.Name,
+			chatc:          rm.chatCh,
+			board:          sim.board,
+			names:          rm.namesMessage,
+			decodeFailures: sim.metrics.inputDecodeFailures,
 		}
-		raddr := sess.RemoteAddr().String()
+		c = rm.join(raddr, c)
 		go func() {
 			c.receiveLoop(context.Background())
 
 			// The session should not be closed, as the only reason the previous
 			// line would return is if the session were closed.
 
-			sim.clientLock.Lock()
-			delete(sim.clients, raddr)
-			sim.clientLock.Unlock()
-			sim.remoteLeftAddrCh <- raddr
+			rm.leave(raddr)
+			sim.release(rm, c.spectator)
 		}()
 
-		sim.clientLock.Lock()
-		sim.clients[raddr] = c
-		sim.clientLock.Unlock()
-		sim.remoteJoinedAddrCh <- raddr
+		slog.Info("client joined", "raddr", raddr, "room", rm.name, "spectator", c.spectator,
+			"name", c.name, "version", join.Version)
+	}
+}
 
-		slog.Info("client joined", "raddr", raddr)
+const maxRoomNameLen = 32
+
+// admit finds a place in the room asked for by join, opening the room if
+// needed. Clients not asking for any room are sent to the fullest room with
+// space left, so that they get to meet each other.
+func (sim *Simulation) admit(join state.Join) (*room, error) {
+	sim.roomLock.Lock()
+	defer sim.roomLock.Unlock()
+
+	name := join.Room
+	if len(name) == 0 {
+		var fullest *room
+		for _, rm := range sim.rooms {
+			if !sim.hasSpace(rm, join.Spectate) {
+				continue
+			}
+			if fullest == nil || rm.players > fullest.players ||
+				rm.players == fullest.players && rm.name < fullest.name {
+				fullest = rm
+			}
+		}
+		if fullest != nil {
+			fullest.enter(join.Spectate)
+			return fullest, nil
+		}
+		for {
+			sim.lastRoom++
+			name = strconv.Itoa(sim.lastRoom)
+			if _, taken := sim.rooms[name]; !taken {
+				break
+			}
+		}
+	}
+
+	if err := validateRoomName(name); err != nil {
+		return nil, err
+	}
+	rm, ok := sim.rooms[name]
+	if !ok {
+		if len(sim.rooms) >= sim.maxRooms {
+			return nil, fmt.Errorf("room %q: already %d rooms open", name, len(sim.rooms))
+		}
+		rm = sim.newRoom(name)
+		sim.rooms[name] = rm
+		go rm.run()
+		slog.Info("opened room", "room", name)
 	}
+	if !sim.hasSpace(rm, join.Spectate) {
+		return nil, fmt.Errorf("room %q: full", name)
+	}
+	rm.enter(join.Spectate)
+	return rm, nil
 }
 
-func (sim *Simulation) Close(ctx context.Context) error {
-	return sim.ln.Close(ctx)
+func (sim *Simulation) hasSpace(rm *room, spectator bool) bool {
+	if spectator {
+		return rm.spectators < sim.spectatorCapacity
+	}
+	return rm.players < sim.roomCapacity
 }
 
-func (sim *Simulation) Layout(int, int) (int, int) {
-	return state.ScreenWidth, state.ScreenHeight
+func validateRoomName(name string) error {
+	if len(name) > maxRoomNameLen {
+		return fmt.Errorf("room name of %d bytes: longer than %d bytes", len(name), maxRoomNameLen)
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("room name %q: only letters, digits, '-' and '_' are allowed", name)
+		}
+	}
+	return nil
 }
 
-func (sim *Simulation) Draw(screen *ebiten.Image) {
-	for _, bullet := range sim.state.Bullets {
-		var m ebiten.GeoM
-		m.Scale(2, 2)
-		m.Translate(bullet.Trans.X, bullet.Trans.Y)
-		screen.DrawImage(assets.Bullet, &ebiten.DrawImageOptions{GeoM: m})
-	}
-
-	for _, asteroid := range sim.state.Asteroids {
-		var m ebiten.GeoM
-		bounds := assets.Rock.Bounds()
-		m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
-		m.Rotate(asteroid.Rotation)
-		m.Scale(
-			state.AsteroidWidth/float64(bounds.Dx()),
-			state.AsteroidHeight/float64(bounds.Dy()),
-		)
-		m.Translate(asteroid.Trans.X, asteroid.Trans.Y)
-		screen.DrawImage(assets.Rock, &ebiten.DrawImageOptions{GeoM: m})
-	}
-
-	for _, player := range sim.state.Players {
-		var m ebiten.GeoM
-		bounds := assets.Player.Bounds()
-		m.Translate(-float64(bounds.Dx()/2), -float64(bounds.Dy()/2))
-		m.Rotate(player.Rotation)
-		m.Scale(
-			state.PlayerWidth/float64(bounds.Dx()),
-			state.PlayerHeight/float64(bounds.Dy()),
-		)
-		m.Translate(player.Trans.X, player.Trans.Y)
-		screen.DrawImage(assets.Player, &ebiten.DrawImageOptions{
-			GeoM: m,
-		})
-
-		op := &text.DrawOptions{}
-		op.GeoM.Translate(player.Trans.X-state.PlayerWidth, player.Trans.Y-state.PlayerHeight)
-		text.Draw(screen, fmt.Sprintf("%d", player.ID), &text.GoTextFace{
-			Source: assets.MPlus1pRegular,
-			Size:   50,
-		}, op)
-	}
-
-	text.Draw(
-		screen,
-		fmt.Sprintf("Total Score: %d", sim.state.TotalScore),
-		&text.GoTextFace{Source: assets.MPlus1pRegular, Size: 60},
-		&text.DrawOptions{},
-	)
+const maxNameLen = 16
+
+// validateName returns name without surrounding spaces, refusing names too
+// long to fit above a ship or which cannot be printed.
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxNameLen {
+		return "", fmt.Errorf("name %q: longer than %d characters", name, maxNameLen)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", fmt.Errorf("name %q: only printable characters are allowed", name)
+		}
+	}
+	return name, nil
 }
 
-func (sim *Simulation) Update() error {
-	dt := time.Second / time.Duration(ebiten.TPS())
+// uniqueName returns name, or name numbered as in "name#2" if it is taken,
+// cutting name short for the number to fit within maxNameLen characters.
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		suffix := fmt.Sprintf("#%d", i)
+		base := []rune(name)
+		base = base[:min(len(base), maxNameLen-len(suffix))]
+		unique = string(base) + suffix
+	}
+	return unique
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), dt)
-	defer cancel()
+// release gives back the place of a client who left rm, closing rm if it is
+// now empty.
+func (sim *Simulation) release(rm *room, spectator bool) {
+	sim.roomLock.Lock()
+	defer sim.roomLock.Unlock()
 
-ADD_PLAYER_LOOP:
-	for {
-		select {
-		case addr := <-sim.remoteJoinedAddrCh:
-			sim.state.AddPlayer(addr)
-		default:
-			break ADD_PLAYER_LOOP
-		}
+	if spectator {
+		rm.spectators--
+	} else {
+		rm.players--
 	}
-REMOVE_PLAYER_LOOP:
-	for {
-		select {
-		case addr := <-sim.remoteLeftAddrCh:
-			sim.state.RemovePlayer(addr)
-		default:
-			break REMOVE_PLAYER_LOOP
-		}
+	if rm.players+rm.spectators > 0 {
+		return
 	}
+	delete(sim.rooms, rm.name)
+	rm.stop()
+	sim.metrics.forgetRoom(rm.name)
+	slog.Info("closed room", "room", rm.name)
+}
 
-	inputs := map[string]state.Input{}
-	sim.clientLock.Lock()
-	for addr, client := range sim.clients {
-		select {
-		case input := <-client.inputc:
-			inputs[addr] = input
-		default:
-		}
+func (sim *Simulation) numRooms() int {
+	sim.roomLock.Lock()
+	defer sim.roomLock.Unlock()
+	return len(sim.rooms)
+}
+
+// room returns the open room called name.
+func (sim *Simulation) room(name string) (*room, error) {
+	sim.roomLock.Lock()
+	defer sim.roomLock.Unlock()
+	rm, ok := sim.rooms[name]
+	if !ok {
+		return nil, fmt.Errorf("room %q: not found", name)
 	}
-	sim.clientLock.Unlock()
+	return rm, nil
+}
 
-	sim.state.Update(dt, inputs)
+// sortedRooms returns the open rooms, the fullest first.
+func (sim *Simulation) sortedRooms() []*room {
+	sim.roomLock.Lock()
+	defer sim.roomLock.Unlock()
+	rooms := make([]*room, 0, len(sim.rooms))
+	for _, rm := range sim.rooms {
+		rooms = append(rooms, rm)
+	}
+	slices.SortFunc(rooms, func(a, b *room) int {
+		return cmp.Or(b.players-a.players, cmp.Compare(a.name, b.name))
+	})
+	return rooms
+}
 
-	stateBuf := bytes.NewBuffer(make([]byte, 0, 6))
-	_ = binary.Write(stateBuf, binary.BigEndian, uint16(1) /* type = state */)
-	_ = binary.Write(stateBuf, binary.BigEndian, sim.lastStateIndex)
-	sim.state.Encode(stateBuf)
-	err := sim.ln.Broadcast(ctx, stateBuf.Bytes())
-	if errors.Is(err, mcp.ErrClosed) {
-		return ebiten.Termination
+// Close stops every room, which finishes their recordings, records the matches
+// they finished into the leaderboard and closes the listener.
+func (sim *Simulation) Close(ctx context.Context) error {
+	sim.roomLock.Lock()
+	rooms := make([]*room, 0, len(sim.rooms))
+	for _, rm := range sim.rooms {
+		rm.stop()
+		rooms = append(rooms, rm)
 	}
-	if errors.Is(err, context.DeadlineExceeded) {
-		return nil
+	sim.roomLock.Unlock()
+	// Rooms stop within a tick, as everything they wait on is cancelled.
+	for _, rm := range rooms {
+		<-rm.done
 	}
-	if err != nil {
-		slog.Warn("failed to send state", "error", err)
-		return nil
+	close(sim.closing)
+	select {
+	case <-sim.recorded:
+	case <-ctx.Done():
+	}
+
+	return sim.ln.Close(ctx)
+}
+
+// shown returns the state of the room shown in the window of the server,
+// which is the fullest one.
+func (sim *Simulation) shown() (string, state.State, state.Names, bool) {
+	rooms := sim.sortedRooms()
+	if len(rooms) == 0 {
+		return "", state.State{}, nil, false
 	}
-	sim.lastStateIndex++
+	s, names, ok := rooms[0].view()
+	return rooms[0].name, s, names, ok
+}
 
+func (sim *Simulation) Layout(int, int) (int, int) {
+	return state.ScreenWidth, state.ScreenHeight
+}
+
+func (sim *Simulation) Draw(screen *ebiten.Image) {
+	name, s, names, ok := sim.shown()
+	if !ok {
+		ebitenutil.DebugPrint(screen, "no rooms open")
+		return
+	}
+	render.State(screen, s, names, render.WholeWorld(s.World))
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("room %s of %d", name, sim.numRooms()))
+}
+
+// Update does nothing, as rooms tick on their own.
+func (sim *Simulation) Update() error {
 	return nil
 }
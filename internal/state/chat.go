@@ -0,0 +1,64 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxChatLen is how many bytes of a chat message the server passes on.
+const MaxChatLen = 120
+
+// Chat is a line of the chat, said by a player or, with no name, by the
+// server itself.
+type Chat struct {
+	Name string
+	Text string
+}
+
+// System tells whether the server said c rather than a player.
+func (c Chat) System() bool {
+	return len(c.Name) == 0
+}
+
+// Encode writes c as its length-prefixed name followed by its text.
+func (c Chat) Encode(buf *bytes.Buffer) {
+	encodeString(buf, c.Name)
+	_, _ = buf.WriteString(c.Text)
+}
+
+// Decode reads a chat line encoded by Encode, taking the text to be the rest
+// of r.
+func (c *Chat) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	name := fr.string()
+	if fr.err != nil {
+		return fmt.Errorf("chat: %w", fr.err)
+	}
+	text := make([]byte, r.Len())
+	_, _ = r.Read(text)
+	*c = Chat{Name: name, Text: string(text)}
+	return nil
+}
+
+// CleanChat returns text without characters which cannot be printed and
+// without surrounding spaces, cut to MaxChatLen bytes without splitting a
+// character.
+func CleanChat(text string) string {
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text))
+	if len(text) <= MaxChatLen {
+		return text
+	}
+	text = text[:MaxChatLen]
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,48 @@
+package state_test
+
+import (
+	"bytes"
+	"multiplayer/internal/state"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChat_Encode(t *testing.T) {
+	tests := []state.Chat{
+		{Name: "Ripley", Text: "get away from her"},
+		{Text: "Hicks joined"},
+		{Name: "Hicks"},
+	}
+	for _, chat := range tests {
+		var buf bytes.Buffer
+		chat.Encode(&buf)
+		var decoded state.Chat
+		assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+		assert.Equal(t, chat, decoded)
+	}
+
+	var chat state.Chat
+	assert.Error(t, chat.Decode(bytes.NewReader(nil)))
+	assert.Error(t, chat.Decode(bytes.NewReader([]byte{5, 'R', 'i'})))
+}
+
+func TestCleanChat(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "hello", want: "hello"},
+		{name: "spaces", text: "  hello \t", want: "hello"},
+		{name: "control", text: "he\x00ll\no", want: "hello"},
+		{name: "long", text: strings.Repeat("a", 200), want: strings.Repeat("a", state.MaxChatLen)},
+		{name: "long multibyte", text: "a" + strings.Repeat("é", 100), want: "a" + strings.Repeat("é", (state.MaxChatLen-1)/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, state.CleanChat(tt.text))
+		})
+	}
+}
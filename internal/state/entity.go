@@ -0,0 +1,154 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// Entity is implemented by every kind of entity kept in Entities.
+type Entity[T any] interface {
+	// EntityID returns the ID which entities are sorted and matched by.
+	EntityID() uint32
+	// Lerp interpolates from the entity to the same entity in a later state.
+	Lerp(other T, t float64, world World) T
+	// Encode writes the fields of the entity clients need.
+	Encode(buf *bytes.Buffer)
+}
+
+// entityPtr is implemented by pointers to entities, decoding what Entity.Encode
+// wrote.
+type entityPtr[T any] interface {
+	*T
+	Decode(r *bytes.Reader) error
+}
+
+// Entities are entities of a single kind, sorted by ID. Entities are only ever
+// appended with increasing IDs and removed in place, which keeps them sorted.
+type Entities[T Entity[T]] []T
+
+// Lerp interpolates every entity that is in both e and other, leaving e
+// untouched. Entities missing from other are kept as they are in e.
+func (e Entities[T]) Lerp(other Entities[T], t float64, world World) Entities[T] {
+	if e == nil {
+		return nil
+	}
+	lerped := make(Entities[T], len(e))
+	copy(lerped, e)
+
+	// Double pointer problem
+	//
+	// Example:
+	// 1, 2, 5, 6, 7, 10
+	// 2, 4, 5, 6, 8, 9
+
+	i, j := 0, 0
+	for i < len(lerped) && j < len(other) {
+		l := lerped[i]
+		r := other[j]
+		if l.EntityID() < r.EntityID() {
+			i++
+		} else if l.EntityID() > r.EntityID() {
+			j++
+		} else {
+			lerped[i] = l.Lerp(r, t, world)
+			i++
+			j++
+		}
+	}
+	return lerped
+}
+
+func (e Entities[T]) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(e)))
+	for _, entity := range e {
+		entity.Encode(buf)
+	}
+}
+
+// trim drops the last entities of e until those dropped make up excess bytes
+// as encoded, leaving e untouched. It returns what is left of e along with how
+// many bytes are still in excess, if dropping every entity did not suffice.
+func (e Entities[T]) trim(excess int) (Entities[T], int) {
+	var buf bytes.Buffer
+	n := len(e)
+	for n > 0 && excess > 0 {
+		buf.Reset()
+		e[n-1].Encode(&buf)
+		excess -= buf.Len()
+		n--
+	}
+	return e[:n:n], excess
+}
+
+func decodeEntities[T Entity[T], P entityPtr[T]](r *bytes.Reader) (Entities[T], error) {
+	var n uint16
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return nil, err
+	}
+	e := make(Entities[T], n)
+	for i := range e {
+		err = P(&e[i]).Decode(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// fieldReader reads the fields of an entity one after another, keeping the
+// first error so that decoders only check it once at the end.
+type fieldReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (fr *fieldReader) read(data any) {
+	if fr.err == nil {
+		fr.err = binary.Read(fr.r, binary.BigEndian, data)
+	}
+}
+
+func (fr *fieldReader) byte() byte {
+	var b byte
+	fr.read(&b)
+	return b
+}
+
+// Positions and rotations are sent as float32, and timers as milliseconds.
+// Positions may lie outside the world, such as those of asteroids coming in,
+// and float32 keeps well below a pixel of error in any world allowed by
+// Rules.Validate.
+
+func encodeTrans(buf *bytes.Buffer, v Vec2) {
+	_ = binary.Write(buf, binary.BigEndian, float32(v.X))
+	_ = binary.Write(buf, binary.BigEndian, float32(v.Y))
+}
+
+func (fr *fieldReader) trans() Vec2 {
+	var x, y float32
+	fr.read(&x)
+	fr.read(&y)
+	return Vec2{X: float64(x), Y: float64(y)}
+}
+
+func encodeRotation(buf *bytes.Buffer, rotation float64) {
+	_ = binary.Write(buf, binary.BigEndian, float32(rotation))
+}
+
+func (fr *fieldReader) rotation() float64 {
+	var rotation float32
+	fr.read(&rotation)
+	return float64(rotation)
+}
+
+func encodeTimer(buf *bytes.Buffer, d time.Duration) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(d.Milliseconds()))
+}
+
+func (fr *fieldReader) timer() time.Duration {
+	var ms uint16
+	fr.read(&ms)
+	return time.Duration(ms) * time.Millisecond
+}
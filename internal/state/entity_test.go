@@ -0,0 +1,218 @@
+package state_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntities_Lerp(t *testing.T) {
+	world := state.World{Width: 100, Height: 100}
+	prev := state.Entities[state.Bullet]{
+		{ID: 1, Trans: state.Vec2{X: 10, Y: 10}},
+		{ID: 2, Trans: state.Vec2{X: 20, Y: 20}},
+		{ID: 5, Trans: state.Vec2{X: 50, Y: 50}},
+		{ID: 6, Trans: state.Vec2{X: 60, Y: 60}},
+	}
+	next := state.Entities[state.Bullet]{
+		{ID: 2, Trans: state.Vec2{X: 30, Y: 20}},
+		{ID: 4, Trans: state.Vec2{X: 40, Y: 40}},
+		{ID: 6, Trans: state.Vec2{X: 60, Y: 80}},
+		{ID: 8, Trans: state.Vec2{X: 80, Y: 80}},
+	}
+
+	lerped := prev.Lerp(next, 0.5, world)
+
+	assert.Equal(t, state.Entities[state.Bullet]{
+		{ID: 1, Trans: state.Vec2{X: 10, Y: 10}},
+		{ID: 2, Trans: state.Vec2{X: 25, Y: 20}},
+		{ID: 5, Trans: state.Vec2{X: 50, Y: 50}},
+		{ID: 6, Trans: state.Vec2{X: 60, Y: 70}},
+	}, lerped)
+	assert.Equal(t, state.Vec2{X: 20, Y: 20}, prev[1].Trans, "lerping must leave the previous entities alone")
+}
+
+func TestState_Encode(t *testing.T) {
+	s := state.Init()
+	s.Mode = state.ModeTeamDeathmatch
+	s.World.Wrap = true
+	s.Paused = true
+	s.TotalScore = 12
+	s.Teams[1].Kills = 3
+	s.Players = state.Entities[state.Player]{{
+		ID:           3,
+		Trans:        state.Vec2{X: 100, Y: 200},
+		Rotation:     0.5,
+		Team:         1,
+		Score:        12,
+		Kills:        3,
+		Deaths:       1,
+		Lives:        2,
+		Dead:         true,
+		Respawn:      1200 * time.Millisecond,
+		Invulnerable: 0,
+	}}
+	s.Bullets = state.Entities[state.Bullet]{{ID: 7, Owner: 3, Trans: state.Vec2{X: 300, Y: 400}, Rotation: -1}}
+	s.Asteroids = state.Entities[state.Asteroid]{
+		{ID: 8, Size: state.AsteroidLarge, Trans: state.Vec2{X: 500, Y: 600}, Rotation: 2},
+		{ID: 9, Size: state.AsteroidSmall, Trans: state.Vec2{X: 700, Y: 800}, Rotation: -2},
+	}
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	decoded := state.State{}
+	decoded.SetRules(s.Rules)
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, s.World, decoded.World)
+	assert.Equal(t, s.Mode, decoded.Mode)
+	assert.Equal(t, s.Paused, decoded.Paused)
+	assert.Equal(t, s.TotalScore, decoded.TotalScore)
+	assert.Equal(t, s.Teams, decoded.Teams)
+	assert.Equal(t, s.Players, decoded.Players)
+	if assert.Len(t, decoded.Bullets, 1) {
+		assert.Equal(t, s.Bullets[0].ID, decoded.Bullets[0].ID)
+		assert.Equal(t, s.Bullets[0].Owner, decoded.Bullets[0].Owner)
+		assert.Equal(t, s.Bullets[0].Trans, decoded.Bullets[0].Trans)
+		assert.InDelta(t, s.Bullets[0].Rotation, decoded.Bullets[0].Rotation, 1e-6)
+	}
+	if assert.Len(t, decoded.Asteroids, 2) {
+		for i := range s.Asteroids {
+			assert.Equal(t, s.Asteroids[i].ID, decoded.Asteroids[i].ID)
+			assert.Equal(t, s.Asteroids[i].Size, decoded.Asteroids[i].Size)
+			assert.Equal(t, s.Asteroids[i].Trans, decoded.Asteroids[i].Trans)
+			assert.InDelta(t, s.Asteroids[i].Rotation, decoded.Asteroids[i].Rotation, 1e-6)
+		}
+	}
+}
+
+func TestState_Decode_short(t *testing.T) {
+	s := state.Init()
+	s.AddPlayer("a")
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	data := buf.Bytes()
+	for n := range len(data) {
+		var decoded state.State
+		assert.Error(t, decoded.Decode(bytes.NewReader(data[:n])), "decoding %d of %d bytes", n, len(data))
+	}
+}
+
+func TestAsteroid_Decode_unknownSize(t *testing.T) {
+	var buf bytes.Buffer
+	state.Asteroid{ID: 1, Size: state.AsteroidSizeCount}.Encode(&buf)
+
+	var decoded state.Asteroid
+	assert.Error(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+}
+
+func TestState_Encode_largeWorld(t *testing.T) {
+	rules := state.DefaultRules()
+	rules.WorldWidth = 100_000
+	rules.WorldHeight = 80_000
+	s := state.Init()
+	s.SetRules(rules)
+	s.Players = state.Entities[state.Player]{{ID: 1, Trans: state.Vec2{X: 99_999.5, Y: 79_000.25}}}
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	decoded := state.State{}
+	decoded.SetRules(rules)
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, s.World, decoded.World)
+	if assert.Len(t, decoded.Players, 1) {
+		assert.Equal(t, s.Players[0].Trans, decoded.Players[0].Trans)
+	}
+}
+
+func TestState_Encode_pastEdges(t *testing.T) {
+	// Asteroids spawn and despawn just past the edges of the world, so their
+	// coordinates must survive going negative or beyond the world.
+	s := state.Init()
+	s.Asteroids = state.Entities[state.Asteroid]{
+		{ID: 1, Size: state.AsteroidLarge, Trans: state.Vec2{X: -state.AsteroidRadius, Y: -state.AsteroidRadius}},
+		{ID: 2, Size: state.AsteroidSmall, Trans: state.Vec2{X: s.World.Width + state.AsteroidRadius, Y: -0.5}},
+	}
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	decoded := state.State{}
+	decoded.SetRules(s.Rules)
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+
+	if assert.Len(t, decoded.Asteroids, 2) {
+		for i := range s.Asteroids {
+			assert.Equal(t, s.Asteroids[i].Trans, decoded.Asteroids[i].Trans)
+		}
+	}
+}
+
+func TestState_Checksum(t *testing.T) {
+	s := state.Init()
+	s.AddPlayer("a")
+	s.AddPlayer("b")
+	s.Players[0].Trans = state.Vec2{X: 100.123456789, Y: 200.987654321}
+	s.Players[0].Respawn = 1234567 * time.Microsecond
+	s.PhaseTime = 2345678 * time.Microsecond
+	s.Bullets = state.Entities[state.Bullet]{{ID: 1, Owner: 1, Trans: state.Vec2{X: 1.0 / 3, Y: 2.0 / 3}, Rotation: math.Pi / 7}}
+	s.Asteroids = state.Entities[state.Asteroid]{{ID: 1, Size: state.AsteroidMedium, Trans: state.Vec2{X: 500.5, Y: 600.25}, Rotation: -math.E}}
+	s.PowerUps = state.Entities[state.PowerUp]{{ID: 1, Kind: state.PowerUpRapidFire, Trans: state.Vec2{X: 10, Y: 20}, Rotation: 0.1}}
+	s.UFOs = state.Entities[state.UFO]{{ID: 1, Trans: state.Vec2{X: -30, Y: 40}, Rotation: 0.2}}
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	decoded := state.State{}
+	decoded.SetRules(s.Rules)
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, s.Checksum(), decoded.Checksum(), "decoding must not change the checksum")
+
+	decoded.Asteroids[0].Trans.X++
+	assert.NotEqual(t, s.Checksum(), decoded.Checksum())
+}
+
+func TestState_Fit(t *testing.T) {
+	const size = 1200 - 3 - 10 // datagram less the mcp and state headers
+
+	s := state.Init()
+	for i := range 8 {
+		s.AddPlayer(fmt.Sprintf("player %d", i))
+	}
+	for i := range 100 {
+		s.Bullets = append(s.Bullets, state.Bullet{ID: uint32(i + 1), Owner: uint16(i%8 + 1), Trans: state.Vec2{X: float64(i), Y: 10}})
+	}
+	for i := range 10 {
+		s.Asteroids = append(s.Asteroids, state.Asteroid{ID: uint32(i + 1), Size: state.AsteroidSmall, Trans: state.Vec2{X: 10, Y: float64(i)}})
+	}
+	s.PowerUps = state.Entities[state.PowerUp]{{ID: 1, Kind: state.PowerUpShield}}
+	s.UFOs = state.Entities[state.UFO]{{ID: 1}, {ID: 2}}
+	var full bytes.Buffer
+	s.Encode(&full)
+	assert.Greater(t, full.Len(), size, "the world must be too full to fit")
+
+	fitted, dropped := s.Fit(size)
+
+	var buf bytes.Buffer
+	fitted.Encode(&buf)
+	assert.LessOrEqual(t, buf.Len(), size)
+	assert.Equal(t, s.Players, fitted.Players)
+	assert.Len(t, fitted.Asteroids, len(s.Asteroids), "bullets must go first")
+	assert.Equal(t, s.Bullets[:len(fitted.Bullets)], fitted.Bullets, "the latest bullets must go first")
+	assert.Equal(t, len(s.Bullets)-len(fitted.Bullets), dropped)
+	assert.Len(t, s.Bullets, 100, "fitting must leave the state alone")
+
+	decoded := state.State{}
+	decoded.SetRules(s.Rules)
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, fitted.Checksum(), decoded.Checksum())
+
+	fitted, dropped = fitted.Fit(size)
+	assert.Zero(t, dropped, "a state which fits must be kept whole")
+	assert.Len(t, fitted.Asteroids, len(s.Asteroids))
+}
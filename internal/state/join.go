@@ -0,0 +1,72 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Join is what a client asks for as it joins a server.
+type Join struct {
+	// Room is the room to join, or empty to leave it to the server.
+	Room string
+	// Spectate joins without a ship, only watching the others play.
+	Spectate bool
+	// Name is the nickname shown to the others, or empty to go by the ID.
+	Name string
+	// Version is the version of the client, for the logs of the server.
+	Version string
+}
+
+const joinFlagSpectate byte = 1 << iota
+
+// Encode writes j as a flags byte followed by the length-prefixed room name,
+// nickname and version.
+func (j Join) Encode(buf *bytes.Buffer) {
+	var flags byte
+	if j.Spectate {
+		flags |= joinFlagSpectate
+	}
+	_ = buf.WriteByte(flags)
+	encodeString(buf, j.Room)
+	encodeString(buf, j.Name)
+	encodeString(buf, j.Version)
+}
+
+// Decode reads a join encoded by Encode. No data at all decodes to the zero
+// join, a player leaving the room to the server.
+func (j *Join) Decode(r *bytes.Reader) error {
+	*j = Join{}
+	if r.Len() == 0 {
+		return nil
+	}
+	fr := fieldReader{r: r}
+	flags := fr.byte()
+	j.Spectate = flags&joinFlagSpectate != 0
+	j.Room = fr.string()
+	j.Name = fr.string()
+	j.Version = fr.string()
+	if fr.err != nil {
+		return fmt.Errorf("join: %w", fr.err)
+	}
+	return nil
+}
+
+// encodeString writes s prefixed by its length as a byte, cutting it to 255
+// bytes.
+func encodeString(buf *bytes.Buffer, s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	_ = buf.WriteByte(byte(len(s)))
+	_, _ = buf.WriteString(s)
+}
+
+func (fr *fieldReader) string() string {
+	n := fr.byte()
+	if fr.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	fr.read(b)
+	return string(b)
+}
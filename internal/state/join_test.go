@@ -0,0 +1,39 @@
+package state_test
+
+import (
+	"bytes"
+	"multiplayer/internal/state"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoin_Encode(t *testing.T) {
+	tests := []state.Join{
+		{},
+		{Room: "lobby"},
+		{Spectate: true},
+		{Room: "lobby", Spectate: true},
+		{Name: "Ripley", Version: "v1.2.3"},
+	}
+	for _, join := range tests {
+		var buf bytes.Buffer
+		join.Encode(&buf)
+		var decoded state.Join
+		assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+		assert.Equal(t, join, decoded)
+	}
+}
+
+func TestJoin_Decode(t *testing.T) {
+	var join state.Join
+	assert.NoError(t, join.Decode(bytes.NewReader(nil)), "no data is a plain join")
+	assert.Equal(t, state.Join{}, join)
+
+	var buf bytes.Buffer
+	state.Join{Room: "lobby", Name: "Ripley"}.Encode(&buf)
+	data := buf.Bytes()
+	for n := 1; n < len(data); n++ {
+		assert.Error(t, join.Decode(bytes.NewReader(data[:n])), "decoding %d of %d bytes", n, len(data))
+	}
+}
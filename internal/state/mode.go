@@ -0,0 +1,90 @@
+package state
+
+import "fmt"
+
+// Mode decides who bullets can hurt. It is chosen when the server starts.
+type Mode uint8
+
+const (
+	// ModeCoop has every player fight the asteroids together. Bullets never
+	// hurt players.
+	ModeCoop Mode = iota
+	// ModeFreeForAll has every player fight everyone else.
+	ModeFreeForAll
+	// ModeTeamDeathmatch splits the players into two teams fighting each
+	// other. Whether teammates can hurt each other depends on
+	// State.FriendlyFire.
+	ModeTeamDeathmatch
+)
+
+var modeNames = [...]string{
+	ModeCoop:           "coop",
+	ModeFreeForAll:     "ffa",
+	ModeTeamDeathmatch: "tdm",
+}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("Mode(%d)", m)
+}
+
+// ParseMode parses the name of a mode, as returned by Mode.String.
+func ParseMode(name string) (Mode, error) {
+	for m, modeName := range modeNames {
+		if modeName == name {
+			return Mode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("mode %q: unknown mode", name)
+}
+
+// TeamCount is the number of teams in ModeTeamDeathmatch. Players of the other
+// modes all belong to the first team.
+const TeamCount = 2
+
+type Team struct {
+	Score  uint32
+	Kills  uint32
+	Deaths uint32
+}
+
+// nextTeam returns the team with the fewest members, so that teams stay
+// balanced as players join.
+func (s *State) nextTeam() uint8 {
+	if s.Mode != ModeTeamDeathmatch {
+		return 0
+	}
+
+	var members [TeamCount]int
+	for _, player := range s.Players {
+		members[player.Team]++
+	}
+	var team uint8
+	for i := range uint8(TeamCount) {
+		if members[i] < members[team] {
+			team = i
+		}
+	}
+	return team
+}
+
+// hurts reports whether bullet can hurt player under the current mode.
+func (s *State) hurts(bullet Bullet, player Player) bool {
+	if player.Dead || player.Invulnerable > 0 || bullet.Owner == player.ID {
+		return false
+	}
+	if bullet.Owner == OwnerHostile {
+		return true
+	}
+
+	switch s.Mode {
+	case ModeFreeForAll:
+		return true
+	case ModeTeamDeathmatch:
+		return bullet.team != player.Team || s.FriendlyFire
+	default:
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package state_test
+
+import (
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []state.Mode{state.ModeCoop, state.ModeFreeForAll, state.ModeTeamDeathmatch} {
+		parsed, err := state.ParseMode(mode.String())
+		assert.NoError(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+
+	_, err := state.ParseMode("battle royale")
+	assert.Error(t, err)
+}
+
+func TestState_Update_bulletPlayerCollision(t *testing.T) {
+	const dt = time.Second / 30
+
+	// The third player joins the team of the first one in team deathmatch.
+	tests := []struct {
+		name         string
+		mode         state.Mode
+		friendlyFire bool
+		target       int
+		killed       bool
+		credited     bool
+	}{
+		{"coop", state.ModeCoop, false, 1, false, false},
+		{"free-for-all", state.ModeFreeForAll, false, 1, true, true},
+		{"tdm enemy", state.ModeTeamDeathmatch, false, 1, true, true},
+		{"tdm teammate", state.ModeTeamDeathmatch, false, 2, false, false},
+		{"tdm teammate with friendly fire", state.ModeTeamDeathmatch, true, 2, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := state.Init()
+			s.Mode = test.mode
+			s.FriendlyFire = test.friendlyFire
+			s.AddPlayer("127.0.0.1:3000")
+			s.AddPlayer("127.0.0.1:3001")
+			s.AddPlayer("127.0.0.1:3002")
+			for i := range s.Players {
+				s.Players[i].Invulnerable = 0
+				s.Players[i].Trans = state.Vec2{X: 100 + 100*float64(i), Y: 100}
+			}
+			s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+			s.Players[test.target].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 200}
+
+			inputs := map[string]state.Input{"127.0.0.1:3000": {Space: true}}
+			for range 10 {
+				s.Update(dt, inputs)
+				inputs = nil
+			}
+
+			shooter, target := s.Players[0], s.Players[test.target]
+			assert.False(t, shooter.Dead)
+			assert.Equal(t, test.killed, target.Dead)
+			assert.Equal(t, test.killed, target.Deaths == 1)
+			assert.Equal(t, test.killed, s.Teams[target.Team].Deaths == 1)
+			assert.Equal(t, test.credited, shooter.Kills == 1)
+			assert.Equal(t, test.credited, s.Teams[shooter.Team].Kills == 1)
+		})
+	}
+}
+
+func TestState_Update_ownBullets(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.Mode = state.ModeFreeForAll
+	s.AddPlayer("127.0.0.1:3000")
+	s.Players[0].Invulnerable = 0
+	s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+
+	s.Update(dt, map[string]state.Input{"127.0.0.1:3000": {Space: true}})
+	if assert.Len(t, s.Bullets, 1) {
+		assert.Equal(t, s.Players[0].ID, s.Bullets[0].Owner)
+	}
+	assert.False(t, s.Players[0].Dead)
+}
+
+func TestState_AddPlayer_teams(t *testing.T) {
+	s := state.Init()
+	s.Mode = state.ModeTeamDeathmatch
+	for i := range 5 {
+		s.AddPlayer(string(rune('a' + i)))
+	}
+
+	var members [state.TeamCount]int
+	for _, player := range s.Players {
+		members[player.Team]++
+	}
+	assert.Equal(t, [state.TeamCount]int{3, 2}, members)
+}
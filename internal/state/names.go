@@ -0,0 +1,55 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Names maps the IDs of players to the nicknames they joined with. Players
+// who did not pick one are missing.
+type Names map[uint16]string
+
+// Of returns the nickname of the player with id, or the ID itself if they
+// have none.
+func (n Names) Of(id uint16) string {
+	if name, ok := n[id]; ok {
+		return name
+	}
+	return strconv.Itoa(int(id))
+}
+
+// Encode writes n as its number of players followed by the ID and the
+// length-prefixed nickname of each of them, in the order of their IDs.
+func (n Names) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(n)))
+	for _, id := range slices.Sorted(maps.Keys(n)) {
+		_ = binary.Write(buf, binary.BigEndian, id)
+		encodeString(buf, n[id])
+	}
+}
+
+// Decode reads names encoded by Encode.
+func (n *Names) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	var count uint16
+	fr.read(&count)
+	names := Names{}
+	for range count {
+		var id uint16
+		fr.read(&id)
+		name := fr.string()
+		if fr.err != nil {
+			break
+		}
+		names[id] = name
+	}
+	if fr.err != nil {
+		return fmt.Errorf("names: %w", fr.err)
+	}
+	*n = names
+	return nil
+}
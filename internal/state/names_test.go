@@ -0,0 +1,41 @@
+package state_test
+
+import (
+	"bytes"
+	"multiplayer/internal/state"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames_Of(t *testing.T) {
+	names := state.Names{1: "Ripley"}
+	assert.Equal(t, "Ripley", names.Of(1))
+	assert.Equal(t, "2", names.Of(2), "players without a nickname go by their ID")
+	assert.Equal(t, "3", state.Names(nil).Of(3))
+}
+
+func TestNames_Encode(t *testing.T) {
+	tests := []state.Names{
+		{},
+		{1: "Ripley"},
+		{1: "Ripley", 2: "Hicks", 7: "Bishop"},
+	}
+	for _, names := range tests {
+		var buf bytes.Buffer
+		names.Encode(&buf)
+		var decoded state.Names
+		assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+		assert.Equal(t, names, decoded)
+	}
+}
+
+func TestNames_Decode(t *testing.T) {
+	var buf bytes.Buffer
+	state.Names{1: "Ripley", 2: "Hicks"}.Encode(&buf)
+	data := buf.Bytes()
+	for n := 0; n < len(data); n++ {
+		var names state.Names
+		assert.Error(t, names.Decode(bytes.NewReader(data[:n])), "decoding %d of %d bytes", n, len(data))
+	}
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+const (
+	PowerUpWidth  = 40
+	PowerUpHeight = 40
+	PowerUpRadius = PowerUpWidth / 2
+)
+
+// PowerUpKind is the effect a power-up grants the player collecting it for
+// Rules.PowerUpDuration.
+type PowerUpKind uint8
+
+const (
+	// PowerUpShield absorbs the next asteroid hit.
+	PowerUpShield PowerUpKind = iota
+	// PowerUpRapidFire shortens the bullet cooldown to
+	// Rules.RapidFireCooldown.
+	PowerUpRapidFire
+	// PowerUpSpreadShot fires three bullets at once, fanned out by
+	// Rules.SpreadShotAngle.
+	PowerUpSpreadShot
+
+	PowerUpKindCount = iota
+)
+
+var powerUpNames = [...]string{
+	PowerUpShield:     "shield",
+	PowerUpRapidFire:  "rapid fire",
+	PowerUpSpreadShot: "spread shot",
+}
+
+func (k PowerUpKind) String() string {
+	if int(k) < len(powerUpNames) {
+		return powerUpNames[k]
+	}
+	return fmt.Sprintf("PowerUpKind(%d)", k)
+}
+
+// Effects are the time left of each power-up effect of a player.
+type Effects [PowerUpKindCount]time.Duration
+
+// Active reports whether the effect of kind is still going.
+func (e Effects) Active(kind PowerUpKind) bool {
+	return e[kind] > 0
+}
+
+type PowerUp struct {
+	ID       uint32
+	Kind     PowerUpKind
+	Trans    Vec2
+	Vel      Vec2
+	Rotation float64
+
+	age time.Duration
+}
+
+func (p PowerUp) Shape() Circle {
+	return Circle{Center: p.Trans, Radius: PowerUpRadius}
+}
+
+func (p PowerUp) EntityID() uint32 {
+	return p.ID
+}
+
+func (p PowerUp) Lerp(other PowerUp, t float64, world World) PowerUp {
+	p.Trans = world.Lerp(p.Trans, other.Trans, t)
+	p.Rotation = rlerp(p.Rotation, other.Rotation, t)
+	return p
+}
+
+func (p PowerUp) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, p.ID)
+	_ = buf.WriteByte(byte(p.Kind))
+	encodeTrans(buf, p.Trans)
+	encodeRotation(buf, p.Rotation)
+}
+
+func (p *PowerUp) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	fr.read(&p.ID)
+	p.Kind = PowerUpKind(fr.byte())
+	p.Trans = fr.trans()
+	p.Rotation = fr.rotation()
+	if fr.err != nil {
+		return fr.err
+	}
+	if p.Kind >= PowerUpKindCount {
+		return fmt.Errorf("power-up kind %d: unknown kind", p.Kind)
+	}
+	return nil
+}
+
+const (
+	powerUpMaxCount = 3
+	powerUpAngVel   = 1
+
+	// shieldGrace keeps players whose shield just broke from being hit again
+	// right away, such as by the pieces of the asteroid.
+	shieldGrace = time.Second
+)
+
+// updatePowerUps spawns a power-up every now and then during waves, drifts
+// them along until they expire, and hands their effects to the players
+// touching them.
+func (s *State) updatePowerUps(delta time.Duration) {
+	r := s.Rules
+	dt := delta.Seconds()
+
+	// power-up spawn
+	if s.Phase == PhaseWave {
+		s.powerUpSpawn -= delta
+		if s.powerUpSpawn <= 0 {
+			s.powerUpSpawn = r.PowerUpInterval.Duration
+			if len(s.PowerUps) < powerUpMaxCount {
+				s.spawnPowerUp()
+			}
+		}
+	}
+
+	// power-up movement and disappearance
+	for i := range s.PowerUps {
+		powerUp := &s.PowerUps[i]
+		powerUp.Trans = s.World.Wrapped(powerUp.Vel.Mul(dt).Add(powerUp.Trans))
+		powerUp.Rotation = wrapAngle(powerUpAngVel*dt + powerUp.Rotation)
+		powerUp.age += delta
+	}
+	s.PowerUps = slices.DeleteFunc(s.PowerUps, func(powerUp PowerUp) bool {
+		return s.World.Outside(powerUp.Shape()) || powerUp.age > r.PowerUpLifetime.Duration
+	})
+
+	// player-power-up collision check
+	for i := range s.Players {
+		player := &s.Players[i]
+		if player.Dead {
+			continue
+		}
+		s.PowerUps = slices.DeleteFunc(s.PowerUps, func(powerUp PowerUp) bool {
+			if !s.World.Overlaps(player.Shape(), powerUp.Shape()) {
+				return false
+			}
+			player.Effects[powerUp.Kind] = r.PowerUpDuration.Duration
+			return true
+		})
+	}
+}
+
+// spawnPowerUp spawns a random power-up somewhere within the world, drifting
+// in a random direction.
+func (s *State) spawnPowerUp() {
+	trans := Vec2{
+		PowerUpRadius + (s.World.Width-2*PowerUpRadius)*rand.Float64(),
+		PowerUpRadius + (s.World.Height-2*PowerUpRadius)*rand.Float64(),
+	}
+	s.PowerUps = append(s.PowerUps, PowerUp{
+		ID:       s.nextPowerUpID,
+		Kind:     PowerUpKind(rand.N(PowerUpKindCount)),
+		Trans:    trans,
+		Vel:      HeadVec2(2 * math.Pi * rand.Float64()).Mul(s.Rules.PowerUpSpeed),
+		Rotation: 0,
+	})
+	s.nextPowerUpID++
+}
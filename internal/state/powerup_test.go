@@ -0,0 +1,139 @@
+package state_test
+
+import (
+	"bytes"
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_Update_collectPowerUp(t *testing.T) {
+	const dt = time.Second / 30
+
+	for kind := range state.PowerUpKind(state.PowerUpKindCount) {
+		t.Run(kind.String(), func(t *testing.T) {
+			s := state.Init()
+			s.AddPlayer("a")
+			s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+			s.PowerUps = state.Entities[state.PowerUp]{{
+				ID:    100,
+				Kind:  kind,
+				Trans: state.Vec2{X: state.ScreenWidth/2 + 30, Y: state.ScreenHeight / 2},
+			}}
+
+			s.Update(dt, nil)
+
+			assert.Empty(t, s.PowerUps)
+			assert.True(t, s.Players[0].Effects.Active(kind))
+			for other := range state.PowerUpKind(state.PowerUpKindCount) {
+				if other != kind {
+					assert.False(t, s.Players[0].Effects.Active(other))
+				}
+			}
+		})
+	}
+}
+
+func TestState_Update_powerUpExpiry(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.PowerUps = state.Entities[state.PowerUp]{{
+		ID:    100,
+		Kind:  state.PowerUpShield,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2},
+	}}
+	for elapsed := time.Duration(0); elapsed < s.Rules.PowerUpLifetime.Duration; elapsed += dt {
+		s.Update(dt, nil)
+	}
+	s.Update(dt, nil)
+	assert.Empty(t, s.PowerUps)
+}
+
+func TestState_Update_shield(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	center := state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+	s.Players[0].Trans = center
+	s.Players[0].Invulnerable = 0
+	s.Players[0].Effects[state.PowerUpShield] = 5 * time.Second
+	s.Asteroids = state.Entities[state.Asteroid]{{ID: 100, Size: state.AsteroidMedium, Trans: center}}
+
+	s.Update(dt, nil)
+
+	player := s.Players[0]
+	assert.False(t, player.Dead)
+	assert.EqualValues(t, state.PlayerLives, player.Lives)
+	assert.False(t, player.Effects.Active(state.PowerUpShield), "the shield absorbs a single hit")
+	assert.True(t, player.Invulnerable > 0)
+	for _, asteroid := range s.Asteroids {
+		assert.NotEqual(t, uint32(100), asteroid.ID, "the asteroid breaks on the shield")
+	}
+}
+
+func TestState_Update_spreadShot(t *testing.T) {
+	const dt = time.Second / 30
+
+	tests := []struct {
+		name    string
+		effect  bool
+		bullets int
+	}{
+		{"single", false, 1},
+		{"spread", true, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := state.Init()
+			s.AddPlayer("a")
+			s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+			if test.effect {
+				s.Players[0].Effects[state.PowerUpSpreadShot] = 5 * time.Second
+			}
+
+			s.Update(dt, map[string]state.Input{"a": {Space: true}})
+
+			assert.Len(t, s.Bullets, test.bullets)
+			for _, bullet := range s.Bullets {
+				assert.Equal(t, s.Players[0].ID, bullet.Owner)
+			}
+			if test.bullets == 3 {
+				assert.InDelta(t, -s.Rules.SpreadShotAngle, s.Bullets[0].Rotation, 1e-9)
+				assert.InDelta(t, s.Rules.SpreadShotAngle, s.Bullets[2].Rotation, 1e-9)
+			}
+		})
+	}
+}
+
+func TestState_Update_effectsWearOff(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Players[0].Effects[state.PowerUpRapidFire] = 2 * dt
+	s.Update(dt, nil)
+	assert.True(t, s.Players[0].Effects.Active(state.PowerUpRapidFire))
+	s.Update(dt, nil)
+	assert.False(t, s.Players[0].Effects.Active(state.PowerUpRapidFire))
+}
+
+func TestState_Encode_powerUps(t *testing.T) {
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Players[0].Effects[state.PowerUpSpreadShot] = 1500 * time.Millisecond
+	s.PowerUps = state.Entities[state.PowerUp]{{ID: 4, Kind: state.PowerUpRapidFire, Trans: state.Vec2{X: 10, Y: 20}, Rotation: 1}}
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	var decoded state.State
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+	if assert.Len(t, decoded.Players, 1) {
+		assert.Equal(t, s.Players[0].Effects, decoded.Players[0].Effects)
+	}
+	assert.Equal(t, s.PowerUps, decoded.PowerUps)
+}
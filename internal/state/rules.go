@@ -0,0 +1,280 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Rules are the tunables of the game. The server loads them once at startup
+// and hands them to every client as it joins, so that both sides agree on how
+// the world behaves.
+type Rules struct {
+	WorldWidth  float64 `json:"world_width"`
+	WorldHeight float64 `json:"world_height"`
+
+	PlayerAngVel          float64  `json:"player_ang_vel"`          // rad/s
+	PlayerAngVelShooting  float64  `json:"player_ang_vel_shooting"` // rad/s, while holding fire
+	PlayerAccel           float64  `json:"player_accel"`            // px/s²
+	PlayerMaxSpeed        float64  `json:"player_max_speed"`        // px/s
+	PlayerLives           uint8    `json:"player_lives"`
+	PlayerRespawnDelay    Duration `json:"player_respawn_delay"`
+	PlayerInvulnerability Duration `json:"player_invulnerability"`
+	PlayerScoreLoss       uint32   `json:"player_score_loss"`
+	PlayerKillScore       uint32   `json:"player_kill_score"`
+
+	BulletSpeed    float64  `json:"bullet_speed"` // px/s
+	BulletCooldown Duration `json:"bullet_cooldown"`
+	BulletLifetime Duration `json:"bullet_lifetime"`
+
+	AsteroidSpeed        float64 `json:"asteroid_speed"` // px/s, during the first wave
+	AsteroidScore        uint32  `json:"asteroid_score"` // for large asteroids
+	AsteroidSplitSpeedup float64 `json:"asteroid_split_speedup"`
+	AsteroidSplitSpread  float64 `json:"asteroid_split_spread"` // rad
+
+	WaveBreather    Duration `json:"wave_breather"`
+	GameOverResults Duration `json:"game_over_results"`
+
+	PowerUpInterval   Duration `json:"power_up_interval"` // between spawns, during waves
+	PowerUpLifetime   Duration `json:"power_up_lifetime"` // until uncollected power-ups vanish
+	PowerUpDuration   Duration `json:"power_up_duration"` // of the effects
+	PowerUpSpeed      float64  `json:"power_up_speed"`    // px/s
+	RapidFireCooldown Duration `json:"rapid_fire_cooldown"`
+	SpreadShotAngle   float64  `json:"spread_shot_angle"` // rad, between bullets
+
+	UFOFirstWave    uint16   `json:"ufo_first_wave"`
+	UFOInterval     Duration `json:"ufo_interval"` // between spawns, during waves
+	UFOLifetime     Duration `json:"ufo_lifetime"` // until they leave
+	UFOSpeed        float64  `json:"ufo_speed"`    // px/s
+	UFOAccel        float64  `json:"ufo_accel"`    // px/s², for steering
+	UFOFireInterval Duration `json:"ufo_fire_interval"`
+	UFOBulletSpeed  float64  `json:"ufo_bullet_speed"` // px/s
+	UFOScore        uint32   `json:"ufo_score"`
+}
+
+// MaxWorldSize is the largest width or height of the world.
+const MaxWorldSize = 1 << 18
+
+// DefaultRules returns the rules used when none are given.
+func DefaultRules() Rules {
+	return Rules{
+		WorldWidth:  ScreenWidth,
+		WorldHeight: ScreenHeight,
+
+		PlayerAngVel:          4,
+		PlayerAngVelShooting:  1.5,
+		PlayerAccel:           500,
+		PlayerMaxSpeed:        400,
+		PlayerLives:           PlayerLives,
+		PlayerRespawnDelay:    Duration{PlayerRespawnDelay},
+		PlayerInvulnerability: Duration{PlayerInvulnerability},
+		PlayerScoreLoss:       10,
+		PlayerKillScore:       10,
+
+		BulletSpeed:    1200,
+		BulletCooldown: Duration{200 * time.Millisecond},
+		BulletLifetime: Duration{2 * time.Second}, // enough to cross the world diagonally
+
+		AsteroidSpeed:        100,
+		AsteroidScore:        1,
+		AsteroidSplitSpeedup: 1.4,
+		AsteroidSplitSpread:  math.Pi / 5,
+
+		WaveBreather:    Duration{5 * time.Second},
+		GameOverResults: Duration{10 * time.Second},
+
+		PowerUpInterval:   Duration{20 * time.Second},
+		PowerUpLifetime:   Duration{15 * time.Second},
+		PowerUpDuration:   Duration{10 * time.Second},
+		PowerUpSpeed:      40,
+		RapidFireCooldown: Duration{80 * time.Millisecond},
+		SpreadShotAngle:   math.Pi / 12,
+
+		UFOFirstWave:    3,
+		UFOInterval:     Duration{25 * time.Second},
+		UFOLifetime:     Duration{30 * time.Second},
+		UFOSpeed:        180,
+		UFOAccel:        240,
+		UFOFireInterval: Duration{1500 * time.Millisecond},
+		UFOBulletSpeed:  600,
+		UFOScore:        20,
+	}
+}
+
+// LoadRules reads rules from the JSON file at path. Fields missing from the
+// file keep their default values.
+func LoadRules(path string) (Rules, error) {
+	rules := DefaultRules()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rules{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&rules)
+	if err != nil {
+		return Rules{}, fmt.Errorf("rules %q: %w", path, err)
+	}
+
+	err = rules.Validate()
+	if err != nil {
+		return Rules{}, fmt.Errorf("rules %q: %w", path, err)
+	}
+	return rules, nil
+}
+
+// With returns r with the tunable of the JSON name key set to value, which is
+// taken as JSON if it is valid JSON and as a JSON string otherwise, so that
+// durations need no quotes.
+func (r Rules) With(key, value string) (Rules, error) {
+	raw := json.RawMessage(value)
+	if !json.Valid(raw) {
+		raw, _ = json.Marshal(value)
+	}
+	data, err := json.Marshal(map[string]json.RawMessage{key: raw})
+	if err != nil {
+		return Rules{}, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&r)
+	if err != nil {
+		return Rules{}, fmt.Errorf("rule %q: %w", key, err)
+	}
+
+	err = r.Validate()
+	if err != nil {
+		return Rules{}, fmt.Errorf("rule %q: %w", key, err)
+	}
+	return r, nil
+}
+
+// Validate reports every value of r which would break the game.
+func (r Rules) Validate() error {
+	var errs []error
+	positive := func(name string, x float64) {
+		if !(x > 0) || math.IsInf(x, 0) {
+			errs = append(errs, fmt.Errorf("%s %v: not a positive number", name, x))
+		}
+	}
+	nonNegative := func(name string, d Duration) {
+		if d.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%s %v: negative duration", name, d))
+		}
+	}
+
+	// The world must fit a player, and stay small enough for positions sent as
+	// float32 to be accurate.
+	if !(r.WorldWidth >= 2*PlayerRadius && r.WorldWidth <= MaxWorldSize) {
+		errs = append(errs, fmt.Errorf("world_width %v: not within [%d, %d]", r.WorldWidth, 2*PlayerRadius, MaxWorldSize))
+	}
+	if !(r.WorldHeight >= 2*PlayerRadius && r.WorldHeight <= MaxWorldSize) {
+		errs = append(errs, fmt.Errorf("world_height %v: not within [%d, %d]", r.WorldHeight, 2*PlayerRadius, MaxWorldSize))
+	}
+
+	positive("player_ang_vel", r.PlayerAngVel)
+	positive("player_ang_vel_shooting", r.PlayerAngVelShooting)
+	positive("player_accel", r.PlayerAccel)
+	positive("player_max_speed", r.PlayerMaxSpeed)
+	if r.PlayerLives == 0 {
+		errs = append(errs, errors.New("player_lives 0: players need at least one life"))
+	}
+	// Respawn and invulnerability timers are sent as 16 bit milliseconds.
+	for _, d := range []struct {
+		name string
+		d    Duration
+	}{
+		{"player_respawn_delay", r.PlayerRespawnDelay},
+		{"player_invulnerability", r.PlayerInvulnerability},
+		{"wave_breather", r.WaveBreather},
+		{"game_over_results", r.GameOverResults},
+		{"power_up_duration", r.PowerUpDuration},
+	} {
+		nonNegative(d.name, d.d)
+		if d.d.Milliseconds() > math.MaxUint16 {
+			errs = append(errs, fmt.Errorf("%s %v: longer than %v", d.name, d.d, math.MaxUint16*time.Millisecond))
+		}
+	}
+
+	positive("bullet_speed", r.BulletSpeed)
+	nonNegative("bullet_cooldown", r.BulletCooldown)
+	if r.BulletLifetime.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("bullet_lifetime %v: not a positive duration", r.BulletLifetime))
+	}
+
+	positive("asteroid_speed", r.AsteroidSpeed)
+	positive("asteroid_split_speedup", r.AsteroidSplitSpeedup)
+	if !(r.AsteroidSplitSpread >= 0 && r.AsteroidSplitSpread <= math.Pi) {
+		errs = append(errs, fmt.Errorf("asteroid_split_spread %v: not within [0, π]", r.AsteroidSplitSpread))
+	}
+
+	if r.PowerUpInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("power_up_interval %v: not a positive duration", r.PowerUpInterval))
+	}
+	nonNegative("power_up_lifetime", r.PowerUpLifetime)
+	if !(r.PowerUpSpeed >= 0) || math.IsInf(r.PowerUpSpeed, 0) {
+		errs = append(errs, fmt.Errorf("power_up_speed %v: not a non-negative number", r.PowerUpSpeed))
+	}
+	nonNegative("rapid_fire_cooldown", r.RapidFireCooldown)
+	if !(r.SpreadShotAngle >= 0 && r.SpreadShotAngle <= math.Pi) {
+		errs = append(errs, fmt.Errorf("spread_shot_angle %v: not within [0, π]", r.SpreadShotAngle))
+	}
+
+	if r.UFOInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("ufo_interval %v: not a positive duration", r.UFOInterval))
+	}
+	nonNegative("ufo_lifetime", r.UFOLifetime)
+	positive("ufo_speed", r.UFOSpeed)
+	positive("ufo_accel", r.UFOAccel)
+	if r.UFOFireInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("ufo_fire_interval %v: not a positive duration", r.UFOFireInterval))
+	}
+	positive("ufo_bullet_speed", r.UFOBulletSpeed)
+
+	return errors.Join(errs...)
+}
+
+// Encode writes r in the form sent to clients as they join.
+func (r Rules) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, r)
+}
+
+func (r *Rules) Decode(rd *bytes.Reader) error {
+	var rules Rules
+	err := binary.Read(rd, binary.BigEndian, &rules)
+	if err != nil {
+		return err
+	}
+	err = rules.Validate()
+	if err != nil {
+		return err
+	}
+	*r = rules
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "1.5s" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration %s: not a string such as \"1.5s\"", data)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
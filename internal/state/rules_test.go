@@ -0,0 +1,142 @@
+package state_test
+
+import (
+	"bytes"
+	"math"
+	"multiplayer/internal/state"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRules_Validate(t *testing.T) {
+	assert.NoError(t, state.DefaultRules().Validate())
+}
+
+func TestRules_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *state.Rules)
+	}{
+		{"tiny world", func(r *state.Rules) { r.WorldWidth = 10 }},
+		{"huge world", func(r *state.Rules) { r.WorldHeight = 1_000_000 }},
+		{"no acceleration", func(r *state.Rules) { r.PlayerAccel = 0 }},
+		{"infinite speed", func(r *state.Rules) { r.PlayerMaxSpeed = math.Inf(1) }},
+		{"NaN bullet speed", func(r *state.Rules) { r.BulletSpeed = math.NaN() }},
+		{"no lives", func(r *state.Rules) { r.PlayerLives = 0 }},
+		{"negative cooldown", func(r *state.Rules) { r.BulletCooldown.Duration = -time.Second }},
+		{"immortal bullets", func(r *state.Rules) { r.BulletLifetime.Duration = 0 }},
+		{"overlong respawn", func(r *state.Rules) { r.PlayerRespawnDelay.Duration = time.Hour }},
+		{"wide spread", func(r *state.Rules) { r.AsteroidSplitSpread = 4 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := state.DefaultRules()
+			test.modify(&rules)
+			assert.Error(t, rules.Validate())
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	t.Run("partial", func(t *testing.T) {
+		rules, err := state.LoadRules(write("partial.json", `{
+			"world_width": 2560,
+			"player_accel": 800,
+			"bullet_cooldown": "150ms"
+		}`))
+		assert.NoError(t, err)
+
+		expected := state.DefaultRules()
+		expected.WorldWidth = 2560
+		expected.PlayerAccel = 800
+		expected.BulletCooldown.Duration = 150 * time.Millisecond
+		assert.Equal(t, expected, rules)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := state.LoadRules(write("unknown.json", `{"player_acel": 800}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("bad duration", func(t *testing.T) {
+		_, err := state.LoadRules(write("duration.json", `{"bullet_cooldown": 150}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := state.LoadRules(write("invalid.json", `{"player_lives": 0}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := state.LoadRules(filepath.Join(dir, "missing.json"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRules_With(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expected func(*state.Rules)
+	}{
+		{"number", "player_accel", "800", func(r *state.Rules) { r.PlayerAccel = 800 }},
+		{"unquoted duration", "bullet_cooldown", "150ms", func(r *state.Rules) { r.BulletCooldown.Duration = 150 * time.Millisecond }},
+		{"quoted duration", "wave_breather", `"3s"`, func(r *state.Rules) { r.WaveBreather.Duration = 3 * time.Second }},
+		{"unknown key", "player_acel", "800", nil},
+		{"bad value", "player_accel", "fast", nil},
+		{"invalid", "player_lives", "0", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := state.DefaultRules().With(tt.key, tt.value)
+			if tt.expected == nil {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			expected := state.DefaultRules()
+			tt.expected(&expected)
+			assert.Equal(t, expected, rules)
+		})
+	}
+}
+
+func TestRules_Encode(t *testing.T) {
+	rules := state.DefaultRules()
+	rules.WorldWidth = 3000
+	rules.BulletLifetime.Duration = 3 * time.Second
+
+	var buf bytes.Buffer
+	rules.Encode(&buf)
+	var decoded state.Rules
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, rules, decoded)
+}
+
+func TestState_SetRules(t *testing.T) {
+	rules := state.DefaultRules()
+	rules.WorldWidth = 3000
+	rules.WorldHeight = 2000
+	rules.PlayerLives = 5
+
+	s := state.Init()
+	s.SetRules(rules)
+	s.AddPlayer("a")
+	assert.Equal(t, 3000.0, s.World.Width)
+	assert.Equal(t, 2000.0, s.World.Height)
+	assert.Equal(t, uint8(5), s.Players[0].Lives)
+}
@@ -2,7 +2,10 @@ package state
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"math"
 	"math/rand/v2"
 	"slices"
@@ -16,8 +19,23 @@ const (
 	PlayerWidth  = 80
 	PlayerHeight = 80
 
+	// AsteroidWidth and AsteroidHeight are the dimensions of medium sized
+	// asteroids; see AsteroidSize.Scale for the others.
 	AsteroidWidth  = 60
 	AsteroidHeight = 60
+
+	BulletWidth  = 12
+	BulletHeight = 12
+
+	PlayerRadius   = PlayerWidth / 2
+	AsteroidRadius = AsteroidWidth / 2
+	BulletRadius   = BulletWidth / 2
+
+	// PlayerLives, PlayerRespawnDelay and PlayerInvulnerability are the
+	// defaults of the matching rules.
+	PlayerLives           = 3
+	PlayerRespawnDelay    = 3 * time.Second
+	PlayerInvulnerability = 3 * time.Second
 )
 
 // A zero valued input does not manipulate the state.
@@ -30,31 +48,126 @@ type State struct {
 	nextPlayerID   uint16
 	nextBulletID   uint32
 	nextAsteroidID uint32
+	nextPowerUpID  uint32
+	nextUFOID      uint32
 
 	// TODO: remove once #21 is merged (also think about how auth would work)
 	idToAddr map[uint16]string
 
-	TotalScore uint32
-	Players    []Player
-	Bullets    []Bullet
-	Asteroids  []Asteroid
-
-	lastAsteroid time.Time
+	// Rules are not part of the encoded state; clients receive them once as
+	// they join. See SetRules.
+	Rules Rules
+
+	World        World
+	Mode         Mode
+	FriendlyFire bool
+	Paused       bool // freezes the game, with Update leaving it alone
+	TotalScore   uint32
+	Teams        [TeamCount]Team
+	Players      Entities[Player]
+	Bullets      Entities[Bullet]
+	Asteroids    Entities[Asteroid]
+	PowerUps     Entities[PowerUp]
+	UFOs         Entities[UFO]
+
+	// Phase is the current stage of the round, with PhaseTime left of it
+	// unless it is a wave. Wave is the number of the latest wave.
+	Phase     Phase
+	PhaseTime time.Duration
+	Wave      uint16
+
+	waveLeft  int           // asteroids of the current wave left to spawn
+	waveSpawn time.Duration // time left until spawning the next one
+
+	powerUpSpawn time.Duration // time left until spawning the next power-up
+	ufoSpawn     time.Duration // time left until spawning the next UFO
 }
 
 func (s *State) AddPlayer(addr string) {
 	s.idToAddr[s.nextPlayerID] = addr
 	s.Players = append(s.Players, Player{
-		ID: s.nextPlayerID,
-		Trans: Vec2{
-			ScreenWidth * rand.Float64(),
-			ScreenHeight * rand.Float64(),
-		},
-		Vel:      Vec2{},
-		Accel:    Vec2{},
-		Rotation: 0,
+		ID:           s.nextPlayerID,
+		Trans:        s.safeSpot(),
+		Vel:          Vec2{},
+		Accel:        Vec2{},
+		Rotation:     0,
+		Team:         s.nextTeam(),
+		Score:        0,
+		Kills:        0,
+		Deaths:       0,
+		Lives:        s.Rules.PlayerLives,
+		Dead:         false,
+		Respawn:      0,
+		Invulnerable: s.Rules.PlayerInvulnerability.Duration,
 	})
+	// Zero is what spectators are told they are, and OwnerHostile owns the
+	// bullets of UFOs, so neither is ever handed out as IDs wrap around.
 	s.nextPlayerID++
+	for s.nextPlayerID == 0 || s.nextPlayerID == OwnerHostile {
+		s.nextPlayerID++
+	}
+}
+
+// PlayerID returns the ID of the player who joined from addr.
+func (s *State) PlayerID(addr string) (uint16, bool) {
+	for id, playerAddr := range s.idToAddr {
+		if playerAddr == addr {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// PlayerAddr returns the address the player with id joined from.
+func (s *State) PlayerAddr(id uint16) (string, bool) {
+	addr, ok := s.idToAddr[id]
+	return addr, ok
+}
+
+// safeSpot picks a spot for a player to (re)spawn at, keeping as far away from
+// the asteroids as a handful of random candidates allow.
+func (s *State) safeSpot() Vec2 {
+	const candidates = 16
+
+	var best Vec2
+	bestClearance := math.Inf(-1)
+	for range candidates {
+		spot := Vec2{
+			PlayerRadius + (s.World.Width-2*PlayerRadius)*rand.Float64(),
+			PlayerRadius + (s.World.Height-2*PlayerRadius)*rand.Float64(),
+		}
+		clearance := math.Inf(1)
+		for _, asteroid := range s.Asteroids {
+			d := s.World.Delta(spot, asteroid.Trans).Magnitude() - asteroid.Size.Radius()
+			clearance = min(clearance, d)
+		}
+		if clearance > bestClearance {
+			best = spot
+			bestClearance = clearance
+		}
+	}
+	return best
+}
+
+// respawn brings player back to life at a safe spot, invulnerable for a while.
+func (s *State) respawn(player *Player) {
+	player.Dead = false
+	player.Respawn = 0
+	player.Invulnerable = s.Rules.PlayerInvulnerability.Duration
+	player.Effects = Effects{}
+	player.Trans = s.safeSpot()
+	player.Vel = Vec2{}
+	player.Accel = Vec2{}
+	player.Rotation = 0
+}
+
+func (s *State) player(id uint16) *Player {
+	for i := range s.Players {
+		if s.Players[i].ID == id {
+			return &s.Players[i]
+		}
+	}
+	return nil
 }
 
 func (s *State) RemovePlayer(addr string) {
@@ -74,28 +187,32 @@ func (s *State) RemovePlayer(addr string) {
 }
 
 func (s *State) Update(delta time.Duration, inputs map[string]Input) {
-	const (
-		playerAngVel         = 4
-		playerAngVelShooting = 1.5
-		playerAccel          = 500
-		playerMaxSpeed       = 400
-		playerScoreLoss      = 10
-
-		bulletSpeed    = 1200
-		bulletCooldown = 200 * time.Millisecond
-
-		asteroidTimeout  = 2 * time.Second
-		asteroidDirRange = 0.75 * math.Pi
-		asteroidScore    = 1
-		asteroidSpeed    = 100
-	)
-
+	if s.Paused {
+		return
+	}
+	r := s.Rules
 	dt := delta.Seconds()
 
 	for i := range len(s.Players) {
 		player := &s.Players[i]
 		input := inputs[s.idToAddr[player.ID]]
 
+		// player respawn
+		if player.Dead {
+			if player.Lives == 0 {
+				continue
+			}
+			player.Respawn -= delta
+			if player.Respawn > 0 {
+				continue
+			}
+			s.respawn(player)
+		}
+		player.Invulnerable = max(0, player.Invulnerable-delta)
+		for kind := range player.Effects {
+			player.Effects[kind] = max(0, player.Effects[kind]-delta)
+		}
+
 		// player controls
 		forward := 0.0
 		rotation := 0.0
@@ -112,118 +229,79 @@ func (s *State) Update(delta time.Duration, inputs map[string]Input) {
 			rotation += 1
 		}
 		if input.Space {
-			rotation *= playerAngVelShooting
+			rotation *= r.PlayerAngVelShooting
 		} else {
-			rotation *= playerAngVel
+			rotation *= r.PlayerAngVel
 		}
 		player.Rotation = wrapAngle(rotation*dt + player.Rotation)
-		player.Accel = HeadVec2(0.5*math.Pi + player.Rotation).Mul(playerAccel * forward)
+		player.Accel = HeadVec2(0.5*math.Pi + player.Rotation).Mul(r.PlayerAccel * forward)
 
 		// player movement
 		player.Trans = player.Accel.Mul(0.5 * dt * dt).Add(player.Vel.Mul(dt)).Add(player.Trans)
 		player.Vel = player.Accel.Mul(dt).Add(player.Vel)
 
 		// player confinement
-		// TODO: perfectly stop at the edge (including sprite)
-		if player.Trans.X < 0 {
-			player.Trans.X = 0
-			player.Vel.X = 0
-		} else if player.Trans.X > ScreenWidth {
-			player.Trans.X = ScreenWidth
+		var clampedX, clampedY bool
+		player.Trans, clampedX, clampedY = s.World.Confine(player.Shape())
+		if clampedX {
 			player.Vel.X = 0
 		}
-		if player.Trans.Y < 0 {
-			player.Trans.Y = 0
-			player.Vel.Y = 0
-		} else if player.Trans.Y > ScreenHeight {
-			player.Trans.Y = ScreenHeight
+		if clampedY {
 			player.Vel.Y = 0
 		}
-		if player.Vel.Magnitude() > playerMaxSpeed {
-			player.Vel = player.Vel.Normalize().Mul(playerMaxSpeed)
+		if player.Vel.Magnitude() > r.PlayerMaxSpeed {
+			player.Vel = player.Vel.Normalize().Mul(r.PlayerMaxSpeed)
 		}
 
 		// player shooting
-		if input.Space && time.Since(player.lastBullet) > bulletCooldown {
-			s.Bullets = append(s.Bullets, Bullet{
-				ID:       s.nextBulletID,
-				Trans:    player.Trans,
-				Rotation: player.Rotation,
-			})
-			s.nextBulletID++
+		cooldown := r.BulletCooldown.Duration
+		if player.Effects.Active(PowerUpRapidFire) {
+			cooldown = r.RapidFireCooldown.Duration
+		}
+		if input.Space && time.Since(player.lastBullet) > cooldown {
+			spread := []float64{0}
+			if player.Effects.Active(PowerUpSpreadShot) {
+				spread = []float64{-r.SpreadShotAngle, 0, r.SpreadShotAngle}
+			}
+			for _, offset := range spread {
+				rotation := wrapAngle(player.Rotation + offset)
+				s.Bullets = append(s.Bullets, Bullet{
+					ID:       s.nextBulletID,
+					Trans:    player.Trans,
+					Vel:      HeadVec2(1.5*math.Pi + rotation).Mul(r.BulletSpeed),
+					Rotation: rotation,
+					Owner:    player.ID,
+					team:     player.Team,
+				})
+				s.nextBulletID++
+			}
 			player.lastBullet = time.Now()
 		}
 	}
 
-	var bulletIndicesToRemove []int
+	s.updateUFOs(delta)
+
 	for i := range len(s.Bullets) {
 		bullet := &s.Bullets[i]
 
 		// bullet movement
-		bullet.Trans = HeadVec2(1.5*math.Pi + bullet.Rotation).Mul(bulletSpeed * dt).Add(bullet.Trans)
-
-		// bullet disappearance
-		// TODO: remove when perfectly out of world
-		if bullet.Trans.X < 0 || bullet.Trans.X > ScreenWidth || bullet.Trans.Y < 0 || bullet.Trans.Y > ScreenHeight {
-			bulletIndicesToRemove = append(bulletIndicesToRemove, i)
-		}
-	}
-	for _, index := range slices.Backward(bulletIndicesToRemove) {
-		s.Bullets = append(s.Bullets[:index], s.Bullets[index+1:]...)
+		bullet.Trans = s.World.Wrapped(bullet.Vel.Mul(dt).Add(bullet.Trans))
+		bullet.age += delta
 	}
 
-	// spawn asteroids randomly at the edges of the world
-	if time.Since(s.lastAsteroid) > asteroidTimeout {
-		const (
-			//               directions:
-			top    = iota //   up     = -π/2
-			bottom        //   down   =  π/2
-			left          //   left   = -π
-			right         //   right  =  0
-		)
-		var trans Vec2
-		var dir float64
-		// TODO: spawn perfectly at the edge
-		switch rand.N(4) {
-		case top:
-			trans.X = ScreenWidth * rand.Float64()
-			trans.Y = 10
-			dir = asteroidDirRange*(rand.Float64()-0.5) + 0.5*math.Pi
-		case bottom:
-			trans.X = ScreenWidth * rand.Float64()
-			trans.Y = ScreenHeight - 10
-			dir = asteroidDirRange*(rand.Float64()-0.5) - 0.5*math.Pi
-		case left:
-			trans.X = 10
-			trans.Y = ScreenHeight * rand.Float64()
-			dir = asteroidDirRange * (rand.Float64() - 0.5)
-		case right:
-			trans.X = ScreenWidth - 10
-			trans.Y = ScreenHeight * rand.Float64()
-			dir = asteroidDirRange*(rand.Float64()-0.5) - math.Pi
-		}
-
-		s.Asteroids = append(s.Asteroids, Asteroid{
-			ID:       s.nextAsteroidID,
-			Trans:    trans,
-			Vel:      HeadVec2(dir).Mul(asteroidSpeed),
-			AngVel:   math.Pi * (rand.Float64() - 0.5),
-			Rotation: 2 * math.Pi * (rand.Float64() - 0.5),
-		})
-		s.nextAsteroidID++
-		s.lastAsteroid = time.Now()
-	}
+	s.direct(delta)
+	s.updatePowerUps(delta)
 
 	var asteroidIndicesToRemove []int
 	for i := range len(s.Asteroids) {
 		asteroid := &s.Asteroids[i]
 
 		// asteroid movement
-		asteroid.Trans = asteroid.Vel.Mul(dt).Add(asteroid.Trans)
+		asteroid.Trans = s.World.Wrapped(asteroid.Vel.Mul(dt).Add(asteroid.Trans))
 		asteroid.Rotation = wrapAngle(asteroid.AngVel*dt + asteroid.Rotation)
 
-		// asteroid confinement
-		if asteroid.Trans.X < 0 || asteroid.Trans.X > ScreenWidth || asteroid.Trans.Y < 0 || asteroid.Trans.Y > ScreenHeight {
+		// asteroid disappearance
+		if s.World.Outside(asteroid.Shape()) {
 			asteroidIndicesToRemove = append(asteroidIndicesToRemove, i)
 		}
 	}
@@ -231,84 +309,345 @@ func (s *State) Update(delta time.Duration, inputs map[string]Input) {
 		s.Asteroids = append(s.Asteroids[:index], s.Asteroids[index+1:]...)
 	}
 
-	// bullet-asteroid collision check
-	// TODO: fix radius stuff
-	bulletIndicesToRemove = nil
-	asteroidIndicesToRemove = nil
+	// splitAsteroid replaces a destroyed asteroid with two smaller and faster
+	// ones that fly apart, unless it was already of the smallest size.
+	splitAsteroid := func(parent Asteroid) {
+		if parent.Size == AsteroidSmall {
+			return
+		}
+
+		size := parent.Size - 1
+		speed := r.AsteroidSplitSpeedup * parent.Vel.Magnitude()
+		if speed == 0 {
+			speed = r.AsteroidSplitSpeedup * s.waveOf(max(s.Wave, 1)).speed
+		}
+		heading := math.Atan2(parent.Vel.Y, parent.Vel.X)
+		for _, side := range [...]float64{-1, 1} {
+			dir := heading + side*r.AsteroidSplitSpread
+			// push them apart sideways so that they merely touch
+			offset := HeadVec2(heading + side*0.5*math.Pi).Mul(size.Radius())
+			s.Asteroids = append(s.Asteroids, Asteroid{
+				ID:       s.nextAsteroidID,
+				Size:     size,
+				Trans:    s.World.Wrapped(parent.Trans.Add(offset)),
+				Vel:      HeadVec2(dir).Mul(speed),
+				AngVel:   math.Pi * (rand.Float64() - 0.5),
+				Rotation: 2 * math.Pi * (rand.Float64() - 0.5),
+			})
+			s.nextAsteroidID++
+		}
+	}
+
+	// bullet collision check
+	//
+	// A bullet covers more distance in a single tick than the width of a small
+	// asteroid, so rather than testing where the bullets ended up, their paths
+	// over the past tick are swept against the asteroids and players. Both
+	// move roughly linearly during a tick, therefore it suffices to sweep the
+	// bullet by its motion relative to each target.
+	type hit struct {
+		bullet int
+		// index of the asteroid, the player or the UFO that was hit, the
+		// others being -1
+		asteroid, player, ufo int
+		t                     float64
+	}
+	var hits []hit
 	for ibullet, bullet := range s.Bullets {
-		for iasteroid, asteroid := range s.Asteroids {
-			if bullet.Trans.Sub(asteroid.Trans).Magnitude() <= AsteroidWidth {
-				bulletIndicesToRemove = append(bulletIndicesToRemove, ibullet)
-				asteroidIndicesToRemove = append(asteroidIndicesToRemove, iasteroid)
-				s.TotalScore += asteroidScore
+		from := Circle{Center: bullet.Trans.Sub(bullet.Vel.Mul(dt)), Radius: BulletRadius}
+		// hostile bullets are only meant for players
+		if bullet.Owner != OwnerHostile {
+			for iasteroid, asteroid := range s.Asteroids {
+				to := Circle{Center: asteroid.Trans.Sub(asteroid.Vel.Mul(dt)), Radius: asteroid.Size.Radius()}
+				if t, ok := s.World.Sweep(from, bullet.Vel.Sub(asteroid.Vel).Mul(dt), to); ok {
+					hits = append(hits, hit{bullet: ibullet, asteroid: iasteroid, player: -1, ufo: -1, t: t})
+				}
+			}
+			for iufo, ufo := range s.UFOs {
+				to := Circle{Center: ufo.Trans.Sub(ufo.Vel.Mul(dt)), Radius: UFORadius}
+				if t, ok := s.World.Sweep(from, bullet.Vel.Sub(ufo.Vel).Mul(dt), to); ok {
+					hits = append(hits, hit{bullet: ibullet, asteroid: -1, player: -1, ufo: iufo, t: t})
+				}
+			}
+		}
+		for iplayer, player := range s.Players {
+			if !s.hurts(bullet, player) {
+				continue
+			}
+			to := Circle{Center: player.Trans.Sub(player.Vel.Mul(dt)), Radius: PlayerRadius}
+			if t, ok := s.World.Sweep(from, bullet.Vel.Sub(player.Vel).Mul(dt), to); ok {
+				hits = append(hits, hit{bullet: ibullet, asteroid: -1, player: iplayer, ufo: -1, t: t})
 			}
 		}
 	}
+	// resolve the earliest hits first so that each bullet destroys the first
+	// target along its path, and each target is destroyed by the first bullet
+	// to reach it
+	slices.SortFunc(hits, func(a, b hit) int { return cmp.Compare(a.t, b.t) })
+	var bulletIndicesToRemove []int
+	var ufoIndicesToRemove []int
+	asteroidIndicesToRemove = nil
+	for _, hit := range hits {
+		if slices.Contains(bulletIndicesToRemove, hit.bullet) {
+			continue
+		}
+		bullet := s.Bullets[hit.bullet]
+		shooter := s.player(bullet.Owner)
+
+		if hit.player >= 0 {
+			target := &s.Players[hit.player]
+			if target.Dead {
+				continue
+			}
+			bulletIndicesToRemove = append(bulletIndicesToRemove, hit.bullet)
+			s.kill(target)
+			if shooter != nil && (s.Mode == ModeFreeForAll || shooter.Team != target.Team) {
+				shooter.Kills++
+				s.Teams[shooter.Team].Kills++
+				s.award(shooter, r.PlayerKillScore)
+			}
+			continue
+		}
+
+		if hit.ufo >= 0 {
+			if slices.Contains(ufoIndicesToRemove, hit.ufo) {
+				continue
+			}
+			bulletIndicesToRemove = append(bulletIndicesToRemove, hit.bullet)
+			ufoIndicesToRemove = append(ufoIndicesToRemove, hit.ufo)
+			if shooter != nil {
+				s.award(shooter, r.UFOScore)
+			} else {
+				s.TotalScore += r.UFOScore
+			}
+			continue
+		}
+
+		if slices.Contains(asteroidIndicesToRemove, hit.asteroid) {
+			continue
+		}
+		bulletIndicesToRemove = append(bulletIndicesToRemove, hit.bullet)
+		asteroidIndicesToRemove = append(asteroidIndicesToRemove, hit.asteroid)
+		asteroid := s.Asteroids[hit.asteroid]
+		if shooter != nil {
+			s.award(shooter, r.AsteroidScore*asteroid.Size.ScoreFactor())
+		} else {
+			s.TotalScore += r.AsteroidScore * asteroid.Size.ScoreFactor()
+		}
+		// appending keeps the indices of the hit asteroids intact
+		splitAsteroid(asteroid)
+	}
+
+	// bullet disappearance
+	for i, bullet := range s.Bullets {
+		if s.World.Outside(bullet.Shape()) || bullet.age > r.BulletLifetime.Duration {
+			bulletIndicesToRemove = append(bulletIndicesToRemove, i)
+		}
+	}
 	slices.Sort(bulletIndicesToRemove)
 	bulletIndicesToRemove = slices.Compact(bulletIndicesToRemove)
 	for _, index := range slices.Backward(bulletIndicesToRemove) {
 		s.Bullets = append(s.Bullets[:index], s.Bullets[index+1:]...)
 	}
 	slices.Sort(asteroidIndicesToRemove)
-	asteroidIndicesToRemove = slices.Compact(asteroidIndicesToRemove)
 	for _, index := range slices.Backward(asteroidIndicesToRemove) {
 		s.Asteroids = append(s.Asteroids[:index], s.Asteroids[index+1:]...)
 	}
+	slices.Sort(ufoIndicesToRemove)
+	for _, index := range slices.Backward(ufoIndicesToRemove) {
+		s.UFOs = append(s.UFOs[:index], s.UFOs[index+1:]...)
+	}
 
 	// player-asteroid collision check
-	// TODO: fix radius stuff
-	var playerIndicesToRemove []int
 	asteroidIndicesToRemove = nil
-	for iplayer, player := range s.Players {
+	for i := range s.Players {
+		player := &s.Players[i]
+		if player.Dead || player.Invulnerable > 0 {
+			continue
+		}
+
 		for iasteroid, asteroid := range s.Asteroids {
-			if player.Trans.Sub(asteroid.Trans).Magnitude() <= AsteroidWidth+PlayerWidth {
-				playerIndicesToRemove = append(playerIndicesToRemove, iplayer)
-				asteroidIndicesToRemove = append(asteroidIndicesToRemove, iasteroid)
-				if s.TotalScore < playerScoreLoss {
-					s.TotalScore = 0
-				} else {
-					s.TotalScore -= playerScoreLoss
-				}
+			if !s.World.Overlaps(player.Shape(), asteroid.Shape()) {
+				continue
 			}
+
+			asteroidIndicesToRemove = append(asteroidIndicesToRemove, iasteroid)
+			s.crash(player)
+			break
 		}
 	}
-	slices.Sort(playerIndicesToRemove)
-	playerIndicesToRemove = slices.Compact(playerIndicesToRemove)
-	for _, index := range slices.Backward(playerIndicesToRemove) {
-		s.Players = append(s.Players[:index], s.Players[index+1:]...)
-	}
 	slices.Sort(asteroidIndicesToRemove)
 	asteroidIndicesToRemove = slices.Compact(asteroidIndicesToRemove)
+	for _, index := range asteroidIndicesToRemove {
+		splitAsteroid(s.Asteroids[index])
+	}
 	for _, index := range slices.Backward(asteroidIndicesToRemove) {
 		s.Asteroids = append(s.Asteroids[:index], s.Asteroids[index+1:]...)
 	}
 }
 
+// crash handles player running into an asteroid or a UFO. Their shield takes
+// the hit if they have one, otherwise they die and lose score.
+func (s *State) crash(player *Player) {
+	if player.Effects.Active(PowerUpShield) {
+		player.Effects[PowerUpShield] = 0
+		player.Invulnerable = shieldGrace
+		return
+	}
+	s.kill(player)
+	s.penalize(player, s.Rules.PlayerScoreLoss)
+}
+
+// kill takes a life from player, who respawns after a while unless that was
+// their last one.
+func (s *State) kill(player *Player) {
+	player.Lives--
+	player.Dead = true
+	player.Respawn = s.Rules.PlayerRespawnDelay.Duration
+	player.Vel = Vec2{}
+	player.Accel = Vec2{}
+	player.Effects = Effects{}
+	player.Deaths++
+	s.Teams[player.Team].Deaths++
+}
+
+// award adds score to player, as well as to their team and the total.
+func (s *State) award(player *Player, score uint32) {
+	player.Score += score
+	s.Teams[player.Team].Score += score
+	s.TotalScore += score
+}
+
+// penalize takes score from player, as well as from their team and the total,
+// without going below zero.
+func (s *State) penalize(player *Player, loss uint32) {
+	player.Score = subScore(player.Score, loss)
+	s.Teams[player.Team].Score = subScore(s.Teams[player.Team].Score, loss)
+	s.TotalScore = subScore(s.TotalScore, loss)
+}
+
+func subScore(score, loss uint32) uint32 {
+	if score < loss {
+		return 0
+	}
+	return score - loss
+}
+
 type Bullet struct {
 	ID       uint32
 	Trans    Vec2
+	Vel      Vec2
 	Rotation float64
+
+	// Owner is the ID of the player who fired the bullet.
+	Owner uint16
+
+	age  time.Duration
+	team uint8 // team of the owner at the time of firing
+}
+
+func (b Bullet) Shape() Circle {
+	return Circle{Center: b.Trans, Radius: BulletRadius}
 }
 
-func (b Bullet) Lerp(other Bullet, t float64) Bullet {
-	b.Trans = b.Trans.Lerp(other.Trans, t)
+func (b Bullet) EntityID() uint32 {
+	return b.ID
+}
+
+func (b Bullet) Lerp(other Bullet, t float64, world World) Bullet {
+	b.Trans = world.Lerp(b.Trans, other.Trans, t)
 	return b
 }
 
+func (b Bullet) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, b.ID)
+	_ = binary.Write(buf, binary.BigEndian, b.Owner)
+	encodeTrans(buf, b.Trans)
+	encodeRotation(buf, b.Rotation)
+}
+
+func (b *Bullet) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	fr.read(&b.ID)
+	fr.read(&b.Owner)
+	b.Trans = fr.trans()
+	b.Rotation = fr.rotation()
+	return fr.err
+}
+
+// AsteroidSize is the size tier of an asteroid. Asteroids split into two of the
+// next smaller tier when destroyed.
+type AsteroidSize uint8
+
+const (
+	AsteroidSmall AsteroidSize = iota
+	AsteroidMedium
+	AsteroidLarge
+
+	AsteroidSizeCount = iota
+)
+
+// Scale returns the factor by which asteroids of this size are scaled relative
+// to AsteroidWidth and AsteroidHeight.
+func (size AsteroidSize) Scale() float64 {
+	return math.Ldexp(1, int(size)-int(AsteroidMedium))
+}
+
+func (size AsteroidSize) Radius() float64 {
+	return AsteroidRadius * size.Scale()
+}
+
+// ScoreFactor returns the factor by which destroying an asteroid of this size
+// is rewarded. Smaller asteroids are harder to hit and thus worth more.
+func (size AsteroidSize) ScoreFactor() uint32 {
+	return 1 << (AsteroidLarge - size)
+}
+
 type Asteroid struct {
 	ID       uint32
+	Size     AsteroidSize
 	Trans    Vec2
 	Vel      Vec2
 	AngVel   float64
 	Rotation float64
 }
 
-func (a Asteroid) Lerp(other Asteroid, t float64) Asteroid {
-	a.Trans = a.Trans.Lerp(other.Trans, t)
+func (a Asteroid) Shape() Circle {
+	return Circle{Center: a.Trans, Radius: a.Size.Radius()}
+}
+
+func (a Asteroid) EntityID() uint32 {
+	return a.ID
+}
+
+func (a Asteroid) Lerp(other Asteroid, t float64, world World) Asteroid {
+	a.Trans = world.Lerp(a.Trans, other.Trans, t)
 	a.Rotation = rlerp(a.Rotation, other.Rotation, t)
 	return a
 }
 
+func (a Asteroid) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, a.ID)
+	_ = buf.WriteByte(byte(a.Size))
+	encodeTrans(buf, a.Trans)
+	encodeRotation(buf, a.Rotation)
+}
+
+func (a *Asteroid) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	fr.read(&a.ID)
+	a.Size = AsteroidSize(fr.byte())
+	a.Trans = fr.trans()
+	a.Rotation = fr.rotation()
+	if fr.err != nil {
+		return fr.err
+	}
+	if a.Size >= AsteroidSizeCount {
+		return fmt.Errorf("asteroid size %d: unknown size", a.Size)
+	}
+	return nil
+}
+
 type Player struct {
 	ID       uint16
 	Trans    Vec2
@@ -316,83 +655,185 @@ type Player struct {
 	Accel    Vec2
 	Rotation float64
 
+	Team   uint8
+	Score  uint32
+	Kills  uint16
+	Deaths uint16
+	Lives  uint8
+	// Dead players wait for Respawn to run out before coming back, unless
+	// they are out of lives.
+	Dead    bool
+	Respawn time.Duration
+	// Invulnerable is the time left until a (re)spawned player can be hit.
+	Invulnerable time.Duration
+	Effects      Effects
+
 	lastBullet time.Time
 }
 
-func (p Player) Lerp(other Player, t float64) Player {
-	p.Trans = p.Trans.Lerp(other.Trans, t)
+func (p Player) Shape() Circle {
+	return Circle{Center: p.Trans, Radius: PlayerRadius}
+}
+
+func (p Player) EntityID() uint32 {
+	return uint32(p.ID)
+}
+
+func (p Player) Lerp(other Player, t float64, world World) Player {
+	p.Trans = world.Lerp(p.Trans, other.Trans, t)
 	p.Rotation = rlerp(p.Rotation, other.Rotation, t)
 	return p
 }
 
+const playerFlagDead byte = 1 << 0
+
+func (p Player) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, p.ID)
+	encodeTrans(buf, p.Trans)
+	encodeRotation(buf, p.Rotation)
+	_ = buf.WriteByte(p.Team)
+	_ = binary.Write(buf, binary.BigEndian, p.Score)
+	_ = binary.Write(buf, binary.BigEndian, p.Kills)
+	_ = binary.Write(buf, binary.BigEndian, p.Deaths)
+	_ = buf.WriteByte(p.Lives)
+	var flags byte
+	if p.Dead {
+		flags |= playerFlagDead
+	}
+	_ = buf.WriteByte(flags)
+	encodeTimer(buf, p.Respawn)
+	encodeTimer(buf, p.Invulnerable)
+	for _, effect := range p.Effects {
+		encodeTimer(buf, effect)
+	}
+}
+
+func (p *Player) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	fr.read(&p.ID)
+	p.Trans = fr.trans()
+	p.Rotation = fr.rotation()
+	p.Team = fr.byte()
+	fr.read(&p.Score)
+	fr.read(&p.Kills)
+	fr.read(&p.Deaths)
+	p.Lives = fr.byte()
+	flags := fr.byte()
+	p.Dead = flags&playerFlagDead != 0
+	p.Respawn = fr.timer()
+	p.Invulnerable = fr.timer()
+	for kind := range p.Effects {
+		p.Effects[kind] = fr.timer()
+	}
+	if fr.err != nil {
+		return fr.err
+	}
+	if p.Team >= TeamCount {
+		return fmt.Errorf("team %d: team does not exist", p.Team)
+	}
+	return nil
+}
+
 func Init() State {
-	return State{
+	s := State{
 		nextPlayerID:   1,
 		nextBulletID:   1,
 		nextAsteroidID: 1,
+		nextPowerUpID:  1,
+		nextUFOID:      1,
 		idToAddr:       map[uint16]string{},
+		Phase:          PhaseBreather,
 	}
+	s.SetRules(DefaultRules())
+	s.PhaseTime = s.Rules.WaveBreather.Duration
+	s.powerUpSpawn = s.Rules.PowerUpInterval.Duration
+	s.ufoSpawn = s.Rules.UFOInterval.Duration
+	return s
+}
+
+// SetRules replaces the rules of s, resizing its world to match.
+func (s *State) SetRules(rules Rules) {
+	s.Rules = rules
+	s.World.Width = rules.WorldWidth
+	s.World.Height = rules.WorldHeight
 }
 
 func (s State) Lerp(other State, t float64) State {
-	{
-		// Double pointer problem
-		//
-		// Example:
-		// 1, 2, 5, 6, 7, 10
-		// 2, 4, 5, 6, 8, 9
-
-		i, j := 0, 0
-		for i < len(s.Players) && j < len(other.Players) {
-			l := &s.Players[i]
-			r := &other.Players[j]
-			if l.ID < r.ID {
-				i++
-			} else if l.ID > r.ID {
-				j++
-			} else {
-				*l = l.Lerp(*r, t)
-				i++
-				j++
-			}
-		}
-	}
+	s.Players = s.Players.Lerp(other.Players, t, s.World)
+	s.Bullets = s.Bullets.Lerp(other.Bullets, t, s.World)
+	s.Asteroids = s.Asteroids.Lerp(other.Asteroids, t, s.World)
+	s.PowerUps = s.PowerUps.Lerp(other.PowerUps, t, s.World)
+	s.UFOs = s.UFOs.Lerp(other.UFOs, t, s.World)
+	return s
+}
 
-	{
-		i, j := 0, 0
-		for i < len(s.Bullets) && j < len(other.Bullets) {
-			l := &s.Bullets[i]
-			r := &other.Bullets[j]
-			if l.ID < r.ID {
-				i++
-			} else if l.ID > r.ID {
-				j++
-			} else {
-				*l = l.Lerp(*r, t)
-				i++
-				j++
-			}
-		}
+// Circle is the collision shape of every entity, derived from the size of its
+// sprite.
+type Circle struct {
+	Center Vec2
+	Radius float64
+}
+
+// Overlaps reports whether c and other intersect. Circles that merely touch
+// are considered overlapping.
+func (c Circle) Overlaps(other Circle) bool {
+	d := c.Center.Sub(other.Center)
+	r := c.Radius + other.Radius
+	return d.X*d.X+d.Y*d.Y <= r*r
+}
+
+// Sweep reports whether c, moving by motion, touches other along the way, and
+// if so, at which fraction t ∈ [0, 1] of motion they first touch. Circles that
+// already overlap touch at t = 0.
+func (c Circle) Sweep(motion Vec2, other Circle) (float64, bool) {
+	// Solve |p + t*motion| = r for the smallest t, where p is the offset from
+	// other to c.
+	p := c.Center.Sub(other.Center)
+	r := c.Radius + other.Radius
+	pp := p.Dot(p) - r*r
+	if pp <= 0 {
+		return 0, true
 	}
 
-	{
-		i, j := 0, 0
-		for i < len(s.Asteroids) && j < len(other.Asteroids) {
-			l := &s.Asteroids[i]
-			r := &other.Asteroids[j]
-			if l.ID < r.ID {
-				i++
-			} else if l.ID > r.ID {
-				j++
-			} else {
-				*l = l.Lerp(*r, t)
-				i++
-				j++
-			}
-		}
+	a := motion.Dot(motion)
+	b := p.Dot(motion)
+	disc := b*b - a*pp
+	if a == 0 || disc < 0 {
+		return 0, false
+	}
+	t := (-b - math.Sqrt(disc)) / a
+	if t < 0 || t > 1 {
+		return 0, false
 	}
+	return t, true
+}
 
-	return s
+// Outside reports whether c lies entirely outside of a world spanning from
+// the origin to (width, height). Circles touching an edge from the outside are
+// not considered outside yet.
+func (c Circle) Outside(width, height float64) bool {
+	return c.Center.X+c.Radius < 0 || c.Center.X-c.Radius > width ||
+		c.Center.Y+c.Radius < 0 || c.Center.Y-c.Radius > height
+}
+
+// Confine returns the center that keeps c entirely inside of a world spanning
+// from the origin to (width, height), along with whether each axis had to be
+// clamped.
+func (c Circle) Confine(width, height float64) (center Vec2, clampedX, clampedY bool) {
+	center = c.Center
+	center.X, clampedX = clamp(center.X, c.Radius, width-c.Radius)
+	center.Y, clampedY = clamp(center.Y, c.Radius, height-c.Radius)
+	return center, clampedX, clampedY
+}
+
+func clamp(x, lo, hi float64) (float64, bool) {
+	if x < lo {
+		return lo, true
+	}
+	if x > hi {
+		return hi, true
+	}
+	return x, false
 }
 
 type Vec2 struct{ X, Y float64 }
@@ -462,6 +903,10 @@ func (v Vec2) Rotate(rotation float64) Vec2 {
 	return v
 }
 
+func (v Vec2) Dot(other Vec2) float64 {
+	return v.X*other.X + v.Y*other.Y
+}
+
 func (v Vec2) Magnitude() float64 {
 	return math.Sqrt(v.X*v.X + v.Y*v.Y)
 }
@@ -510,132 +955,137 @@ func (i *Input) Decode(r *bytes.Reader) error {
 	return nil
 }
 
-func (s State) Encode(buf *bytes.Buffer) {
-	_ = binary.Write(buf, binary.BigEndian, s.TotalScore)
+const (
+	stateFlagWrap byte = 1 << iota
+	stateFlagFriendlyFire
+	stateFlagPaused
+)
 
-	_ = binary.Write(buf, binary.BigEndian, uint16(len(s.Players)))
-	for _, player := range s.Players {
-		_ = binary.Write(buf, binary.BigEndian, player.ID)
-		_ = binary.Write(buf, binary.BigEndian, uint16(player.Trans.X))
-		_ = binary.Write(buf, binary.BigEndian, uint16(player.Trans.Y))
-		_ = binary.Write(buf, binary.BigEndian, float32(player.Rotation))
+func (s State) Encode(buf *bytes.Buffer) {
+	var flags byte
+	if s.World.Wrap {
+		flags |= stateFlagWrap
 	}
-
-	_ = binary.Write(buf, binary.BigEndian, uint16(len(s.Bullets)))
-	for _, bullet := range s.Bullets {
-		_ = binary.Write(buf, binary.BigEndian, bullet.ID)
-		_ = binary.Write(buf, binary.BigEndian, uint16(bullet.Trans.X))
-		_ = binary.Write(buf, binary.BigEndian, uint16(bullet.Trans.Y))
-		_ = binary.Write(buf, binary.BigEndian, float32(bullet.Rotation))
+	if s.FriendlyFire {
+		flags |= stateFlagFriendlyFire
+	}
+	if s.Paused {
+		flags |= stateFlagPaused
 	}
+	_ = buf.WriteByte(flags)
+	_ = buf.WriteByte(byte(s.Mode))
+	_ = buf.WriteByte(byte(s.Phase))
+	_ = binary.Write(buf, binary.BigEndian, uint16(s.PhaseTime.Milliseconds()))
+	_ = binary.Write(buf, binary.BigEndian, s.Wave)
 
-	_ = binary.Write(buf, binary.BigEndian, uint16(len(s.Asteroids)))
-	for _, asteroid := range s.Asteroids {
-		_ = binary.Write(buf, binary.BigEndian, asteroid.ID)
-		_ = binary.Write(buf, binary.BigEndian, uint16(asteroid.Trans.X))
-		_ = binary.Write(buf, binary.BigEndian, uint16(asteroid.Trans.Y))
-		_ = binary.Write(buf, binary.BigEndian, float32(asteroid.Rotation))
+	_ = binary.Write(buf, binary.BigEndian, s.TotalScore)
+	for _, team := range s.Teams {
+		_ = binary.Write(buf, binary.BigEndian, team)
 	}
+
+	s.Players.Encode(buf)
+	s.Bullets.Encode(buf)
+	s.Asteroids.Encode(buf)
+	s.PowerUps.Encode(buf)
+	s.UFOs.Encode(buf)
 }
 
 func (s *State) Decode(r *bytes.Reader) error {
-	err := binary.Read(r, binary.BigEndian, &s.TotalScore)
+	flags, err := r.ReadByte()
 	if err != nil {
 		return err
 	}
-
-	var playersLen uint16
-	err = binary.Read(r, binary.BigEndian, &playersLen)
+	// The size of the world comes from the rules, which are not encoded.
+	s.World = World{
+		Width:  s.Rules.WorldWidth,
+		Height: s.Rules.WorldHeight,
+		Wrap:   flags&stateFlagWrap != 0,
+	}
+	s.FriendlyFire = flags&stateFlagFriendlyFire != 0
+	s.Paused = flags&stateFlagPaused != 0
+	mode, err := r.ReadByte()
 	if err != nil {
 		return err
 	}
-	s.Players = make([]Player, playersLen)
-	for i := range playersLen {
-		err = binary.Read(r, binary.BigEndian, &s.Players[i].ID)
-		if err != nil {
-			return err
-		}
-		var tx uint16
-		err = binary.Read(r, binary.BigEndian, &tx)
-		if err != nil {
-			return err
-		}
-		s.Players[i].Trans.X = float64(tx)
-		var ty uint16
-		err = binary.Read(r, binary.BigEndian, &ty)
-		if err != nil {
-			return err
-		}
-		s.Players[i].Trans.Y = float64(ty)
-		var rotation float32
-		err = binary.Read(r, binary.BigEndian, &rotation)
-		if err != nil {
-			return err
-		}
-		s.Players[i].Rotation = float64(rotation)
+	s.Mode = Mode(mode)
+	phase, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	s.Phase = Phase(phase)
+	var phaseTime uint16
+	err = binary.Read(r, binary.BigEndian, &phaseTime)
+	if err != nil {
+		return err
+	}
+	s.PhaseTime = time.Duration(phaseTime) * time.Millisecond
+	err = binary.Read(r, binary.BigEndian, &s.Wave)
+	if err != nil {
+		return err
 	}
 
-	var bulletsLen uint16
-	err = binary.Read(r, binary.BigEndian, &bulletsLen)
+	err = binary.Read(r, binary.BigEndian, &s.TotalScore)
 	if err != nil {
 		return err
 	}
-	s.Bullets = make([]Bullet, bulletsLen)
-	for i := range bulletsLen {
-		err = binary.Read(r, binary.BigEndian, &s.Bullets[i].ID)
-		if err != nil {
-			return err
-		}
-		var tx uint16
-		err = binary.Read(r, binary.BigEndian, &tx)
-		if err != nil {
-			return err
-		}
-		s.Bullets[i].Trans.X = float64(tx)
-		var ty uint16
-		err = binary.Read(r, binary.BigEndian, &ty)
+	for i := range s.Teams {
+		err = binary.Read(r, binary.BigEndian, &s.Teams[i])
 		if err != nil {
 			return err
 		}
-		s.Bullets[i].Trans.Y = float64(ty)
-		var rotation float32
-		err = binary.Read(r, binary.BigEndian, &rotation)
-		if err != nil {
-			return err
-		}
-		s.Bullets[i].Rotation = float64(rotation)
 	}
 
-	var asteroidsLen uint16
-	err = binary.Read(r, binary.BigEndian, &asteroidsLen)
+	s.Players, err = decodeEntities[Player](r)
 	if err != nil {
 		return err
 	}
-	s.Asteroids = make([]Asteroid, asteroidsLen)
-	for i := range asteroidsLen {
-		err = binary.Read(r, binary.BigEndian, &s.Asteroids[i].ID)
-		if err != nil {
-			return err
-		}
-		var tx uint16
-		err = binary.Read(r, binary.BigEndian, &tx)
-		if err != nil {
-			return err
-		}
-		s.Asteroids[i].Trans.X = float64(tx)
-		var ty uint16
-		err = binary.Read(r, binary.BigEndian, &ty)
-		if err != nil {
-			return err
-		}
-		s.Asteroids[i].Trans.Y = float64(ty)
-		var rotation float32
-		err = binary.Read(r, binary.BigEndian, &rotation)
-		if err != nil {
-			return err
-		}
-		s.Asteroids[i].Rotation = float64(rotation)
+	s.Bullets, err = decodeEntities[Bullet](r)
+	if err != nil {
+		return err
+	}
+	s.Asteroids, err = decodeEntities[Asteroid](r)
+	if err != nil {
+		return err
+	}
+	s.PowerUps, err = decodeEntities[PowerUp](r)
+	if err != nil {
+		return err
+	}
+	s.UFOs, err = decodeEntities[UFO](r)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// Fit returns s with as few bullets, asteroids, power-ups and UFOs left out
+// as it takes for it to encode into size bytes, along with how many were left
+// out. Bullets go first, then asteroids, power-ups and UFOs, the latest of each
+// first. Players are always kept, so s may still not fit if they alone don't.
+func (s State) Fit(size int) (State, int) {
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	excess := buf.Len() - size
+	if excess <= 0 {
+		return s, 0
+	}
+	n := len(s.Bullets) + len(s.Asteroids) + len(s.PowerUps) + len(s.UFOs)
+	s.Bullets, excess = s.Bullets.trim(excess)
+	s.Asteroids, excess = s.Asteroids.trim(excess)
+	s.PowerUps, excess = s.PowerUps.trim(excess)
+	s.UFOs, _ = s.UFOs.trim(excess)
+	return s, n - len(s.Bullets) - len(s.Asteroids) - len(s.PowerUps) - len(s.UFOs)
+}
+
+// Checksum hashes s as encoded, so everything left out of snapshots is left
+// out of the checksum, and everything else is quantized the way it is sent.
+// A client decoding a snapshot must end up with the checksum the server came
+// up with before encoding it, or the two disagree on the state of the game.
+// It is the CRC-32 of the encoded bytes, so whoever holds them already can hash
+// them instead.
+func (s State) Checksum() uint32 {
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	return crc32.ChecksumIEEE(buf.Bytes())
+}
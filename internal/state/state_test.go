@@ -0,0 +1,366 @@
+package state_test
+
+import (
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircle_Overlaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     state.Circle
+		expected bool
+	}{
+		{
+			name:     "concentric",
+			a:        state.Circle{Center: state.Vec2{X: 100, Y: 100}, Radius: 10},
+			b:        state.Circle{Center: state.Vec2{X: 100, Y: 100}, Radius: 1},
+			expected: true,
+		},
+		{
+			name:     "touching horizontally",
+			a:        state.Circle{Center: state.Vec2{X: 0, Y: 0}, Radius: 10},
+			b:        state.Circle{Center: state.Vec2{X: 30, Y: 0}, Radius: 20},
+			expected: true,
+		},
+		{
+			name:     "touching diagonally",
+			a:        state.Circle{Center: state.Vec2{X: 0, Y: 0}, Radius: 2},
+			b:        state.Circle{Center: state.Vec2{X: 3, Y: 4}, Radius: 3},
+			expected: true,
+		},
+		{
+			name:     "barely apart",
+			a:        state.Circle{Center: state.Vec2{X: 0, Y: 0}, Radius: 10},
+			b:        state.Circle{Center: state.Vec2{X: 30.001, Y: 0}, Radius: 20},
+			expected: false,
+		},
+		{
+			name:     "within diameter but not radii",
+			a:        state.Circle{Center: state.Vec2{X: 0, Y: 0}, Radius: state.BulletRadius},
+			b:        state.Circle{Center: state.Vec2{X: state.AsteroidWidth, Y: 0}, Radius: state.AsteroidRadius},
+			expected: false,
+		},
+		{
+			name:     "zero radius inside",
+			a:        state.Circle{Center: state.Vec2{X: 5, Y: 5}, Radius: 0},
+			b:        state.Circle{Center: state.Vec2{X: 0, Y: 0}, Radius: 10},
+			expected: true,
+		},
+		{
+			name:     "zero radii apart",
+			a:        state.Circle{Center: state.Vec2{X: 5, Y: 5}, Radius: 0},
+			b:        state.Circle{Center: state.Vec2{X: 5, Y: 6}, Radius: 0},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.a.Overlaps(test.b))
+			assert.Equal(t, test.expected, test.b.Overlaps(test.a))
+		})
+	}
+}
+
+func TestCircle_Outside(t *testing.T) {
+	const width, height = 200, 100
+
+	tests := []struct {
+		name     string
+		circle   state.Circle
+		expected bool
+	}{
+		{"inside", state.Circle{Center: state.Vec2{X: 100, Y: 50}, Radius: 10}, false},
+		{"center past left edge", state.Circle{Center: state.Vec2{X: -5, Y: 50}, Radius: 10}, false},
+		{"touching left edge", state.Circle{Center: state.Vec2{X: -10, Y: 50}, Radius: 10}, false},
+		{"past left edge", state.Circle{Center: state.Vec2{X: -10.5, Y: 50}, Radius: 10}, true},
+		{"touching right edge", state.Circle{Center: state.Vec2{X: 210, Y: 50}, Radius: 10}, false},
+		{"past right edge", state.Circle{Center: state.Vec2{X: 210.5, Y: 50}, Radius: 10}, true},
+		{"touching top edge", state.Circle{Center: state.Vec2{X: 100, Y: -10}, Radius: 10}, false},
+		{"past top edge", state.Circle{Center: state.Vec2{X: 100, Y: -10.5}, Radius: 10}, true},
+		{"touching bottom edge", state.Circle{Center: state.Vec2{X: 100, Y: 110}, Radius: 10}, false},
+		{"past bottom edge", state.Circle{Center: state.Vec2{X: 100, Y: 110.5}, Radius: 10}, true},
+		{"past corner", state.Circle{Center: state.Vec2{X: -11, Y: -11}, Radius: 10}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.circle.Outside(width, height))
+		})
+	}
+}
+
+func TestCircle_Confine(t *testing.T) {
+	const width, height = 200, 100
+
+	tests := []struct {
+		name               string
+		circle             state.Circle
+		expected           state.Vec2
+		clampedX, clampedY bool
+	}{
+		{"inside", state.Circle{Center: state.Vec2{X: 100, Y: 50}, Radius: 10}, state.Vec2{X: 100, Y: 50}, false, false},
+		{"touching edges", state.Circle{Center: state.Vec2{X: 10, Y: 90}, Radius: 10}, state.Vec2{X: 10, Y: 90}, false, false},
+		{"sprite over left edge", state.Circle{Center: state.Vec2{X: 5, Y: 50}, Radius: 10}, state.Vec2{X: 10, Y: 50}, true, false},
+		{"center over right edge", state.Circle{Center: state.Vec2{X: 250, Y: 50}, Radius: 10}, state.Vec2{X: 190, Y: 50}, true, false},
+		{"sprite over top edge", state.Circle{Center: state.Vec2{X: 100, Y: 0}, Radius: 10}, state.Vec2{X: 100, Y: 10}, false, true},
+		{"into corner", state.Circle{Center: state.Vec2{X: 199, Y: 99}, Radius: 10}, state.Vec2{X: 190, Y: 90}, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			center, clampedX, clampedY := test.circle.Confine(width, height)
+			assert.Equal(t, test.expected, center)
+			assert.Equal(t, test.clampedX, clampedX)
+			assert.Equal(t, test.clampedY, clampedY)
+		})
+	}
+}
+
+func TestState_Update_bulletAsteroidCollision(t *testing.T) {
+	const dt = time.Second / 30
+
+	tests := []struct {
+		name   string
+		offset float64 // distance between their centers after the bullet moves
+		hit    bool
+	}{
+		{"head on", 0, true},
+		{"grazing", state.AsteroidRadius + state.BulletRadius - 1, true},
+		{"near miss", state.AsteroidRadius + state.BulletRadius + 1, false},
+		{"asteroid diameter away", state.AsteroidWidth, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := state.Init()
+			s.Bullets = []state.Bullet{{
+				ID:    1,
+				Trans: state.Vec2{X: state.ScreenWidth/2 + test.offset, Y: state.ScreenHeight / 2},
+				Vel:   state.Vec2{X: 0, Y: -1200},
+			}}
+			s.Asteroids = []state.Asteroid{{
+				ID:    100, // clear of the IDs given to spawned asteroids
+				Size:  state.AsteroidMedium,
+				Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 40},
+			}}
+
+			s.Update(dt, nil)
+
+			hit := true
+			for _, asteroid := range s.Asteroids {
+				if asteroid.ID == 100 {
+					hit = false
+				}
+			}
+			assert.Equal(t, test.hit, hit)
+			assert.Equal(t, test.hit, s.TotalScore > 0)
+		})
+	}
+}
+
+func TestState_Update_fastBulletThroughAsteroid(t *testing.T) {
+	const (
+		dt          = time.Second / 30
+		bulletSpeed = 3000 // 100px per tick
+	)
+
+	// Every start position and lateral offset below puts the bullet on a path
+	// through the asteroid, yet neither the position before nor the one after
+	// the tick overlaps it, as 37 + 26 - 100 = -37.
+	asteroid := state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+	for offset := 0.0; offset < state.AsteroidRadius+state.BulletRadius; offset += 3 {
+		for start := 0.0; start <= 26; start += 2 {
+			s := state.Init()
+			s.Bullets = []state.Bullet{{
+				ID:    1,
+				Trans: state.Vec2{X: asteroid.X + offset, Y: asteroid.Y + 37 + start},
+				Vel:   state.Vec2{X: 0, Y: -bulletSpeed},
+			}}
+			s.Asteroids = []state.Asteroid{{ID: 100, Size: state.AsteroidMedium, Trans: asteroid}}
+
+			s.Update(dt, nil)
+
+			for _, a := range s.Asteroids {
+				if a.ID == 100 {
+					t.Fatalf("bullet at offset %v, start %v passed through the asteroid", offset, start)
+				}
+			}
+			assert.Empty(t, s.Bullets)
+			assert.Equal(t, state.AsteroidMedium.ScoreFactor(), s.TotalScore)
+		}
+	}
+}
+
+func TestState_Update_earliestHit(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.Bullets = []state.Bullet{{
+		ID:    1,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2},
+		Vel:   state.Vec2{X: 0, Y: -6000},
+	}}
+	s.Asteroids = []state.Asteroid{
+		{ID: 100, Size: state.AsteroidSmall, Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 150}},
+		{ID: 101, Size: state.AsteroidSmall, Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 50}},
+	}
+
+	s.Update(dt, nil)
+
+	var ids []uint32
+	for _, a := range s.Asteroids {
+		ids = append(ids, a.ID)
+	}
+	assert.Contains(t, ids, uint32(100))
+	assert.NotContains(t, ids, uint32(101))
+	assert.Equal(t, state.AsteroidSmall.ScoreFactor(), s.TotalScore)
+}
+
+func TestState_Update_asteroidSplitting(t *testing.T) {
+	const dt = time.Second / 30
+
+	tests := []struct {
+		name     string
+		size     state.AsteroidSize
+		children int
+		score    uint32
+	}{
+		{"large", state.AsteroidLarge, 2, 1},
+		{"medium", state.AsteroidMedium, 2, 2},
+		{"small", state.AsteroidSmall, 0, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := state.Asteroid{
+				ID:    100,
+				Size:  test.size,
+				Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 40},
+				Vel:   state.Vec2{X: 100, Y: 0},
+			}
+			s := state.Init()
+			s.Bullets = []state.Bullet{{
+				ID:    1,
+				Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2},
+				Vel:   state.Vec2{X: 0, Y: -1200},
+			}}
+			s.Asteroids = []state.Asteroid{parent}
+
+			s.Update(dt, nil)
+
+			var children []state.Asteroid
+			for _, a := range s.Asteroids {
+				assert.NotEqual(t, parent.ID, a.ID)
+				if a.Size < parent.Size {
+					children = append(children, a)
+				}
+			}
+			assert.Len(t, children, test.children)
+			assert.Equal(t, test.score, s.TotalScore)
+			if len(children) != 2 {
+				return
+			}
+
+			a, b := children[0], children[1]
+			assert.Equal(t, test.size-1, a.Size)
+			assert.Equal(t, test.size-1, b.Size)
+			assert.Greater(t, a.Vel.Magnitude(), parent.Vel.Magnitude())
+			assert.Greater(t, b.Vel.Magnitude(), parent.Vel.Magnitude())
+			assert.Less(t, a.Vel.Y*b.Vel.Y, 0.0, "children should diverge")
+			assert.InDelta(t, a.Size.Radius()+b.Size.Radius(), a.Trans.Sub(b.Trans).Magnitude(), 1e-9,
+				"children should touch rather than overlap")
+		})
+	}
+}
+
+func TestState_Update_playerLives(t *testing.T) {
+	const dt = time.Second / 30
+	center := state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+
+	s := state.Init()
+	s.AddPlayer("127.0.0.1:3000")
+	player := &s.Players[0]
+	assert.EqualValues(t, state.PlayerLives, player.Lives)
+	assert.True(t, player.Invulnerable > 0)
+
+	// invulnerable players survive hits
+	player.Trans = center
+	s.Asteroids = []state.Asteroid{{ID: 100, Size: state.AsteroidSmall, Trans: center}}
+	s.Update(dt, nil)
+	player = &s.Players[0]
+	assert.False(t, player.Dead)
+
+	player.Invulnerable = 0
+	s.Asteroids = []state.Asteroid{{ID: 101, Size: state.AsteroidSmall, Trans: center}}
+	s.Update(dt, nil)
+	player = &s.Players[0]
+	assert.True(t, player.Dead)
+	assert.EqualValues(t, state.PlayerLives-1, player.Lives)
+	assert.Equal(t, state.PlayerRespawnDelay, player.Respawn)
+
+	for elapsed := time.Duration(0); elapsed < state.PlayerRespawnDelay; elapsed += dt {
+		assert.True(t, s.Players[0].Dead)
+		s.Asteroids = nil
+		s.Update(dt, nil)
+	}
+	player = &s.Players[0]
+	assert.False(t, player.Dead)
+	assert.True(t, player.Invulnerable > 0)
+
+	// out of lives
+	player.Lives = 1
+	player.Invulnerable = 0
+	player.Trans = center
+	s.Asteroids = []state.Asteroid{{ID: 102, Size: state.AsteroidSmall, Trans: center}}
+	s.Update(dt, nil)
+	for range 2 * state.PlayerRespawnDelay / dt {
+		s.Asteroids = nil
+		s.Update(dt, nil)
+	}
+	player = &s.Players[0]
+	assert.True(t, player.Dead)
+	assert.Zero(t, player.Lives)
+}
+
+func TestState_Update_playerScore(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("127.0.0.1:3000")
+	s.AddPlayer("127.0.0.1:3001")
+	s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+	s.Players[1].Trans = state.Vec2{X: state.ScreenWidth / 4, Y: state.ScreenHeight / 2}
+	s.Asteroids = []state.Asteroid{{
+		ID:    100,
+		Size:  state.AsteroidLarge,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 200},
+	}}
+
+	inputs := map[string]state.Input{"127.0.0.1:3000": {Space: true}}
+	for range 10 {
+		s.Update(dt, inputs)
+		inputs = nil
+	}
+
+	assert.Equal(t, state.AsteroidLarge.ScoreFactor(), s.Players[0].Score)
+	assert.Zero(t, s.Players[1].Score)
+	assert.Equal(t, s.Players[0].Score, s.TotalScore)
+}
+
+func TestState_Update_paused(t *testing.T) {
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Players[0].Trans = state.Vec2{X: 100, Y: 100}
+	s.Paused = true
+
+	s.Update(time.Second, map[string]state.Input{"a": {Up: true, Space: true}})
+
+	assert.Equal(t, state.Vec2{X: 100, Y: 100}, s.Players[0].Trans)
+	assert.Empty(t, s.Bullets)
+	assert.Equal(t, state.DefaultRules().WaveBreather.Duration, s.PhaseTime)
+}
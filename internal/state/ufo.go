@@ -0,0 +1,217 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+const (
+	UFOWidth  = 70
+	UFOHeight = 70
+	UFORadius = UFOWidth / 2
+)
+
+// OwnerHostile is the Bullet.Owner of bullets fired by UFOs. Player IDs count
+// up from one and skip it as they wrap around.
+const OwnerHostile uint16 = math.MaxUint16
+
+// UFO is a hostile saucer hunting the players. It enters from an edge, closes
+// in on the nearest player while steering clear of asteroids, and shoots at
+// them every Rules.UFOFireInterval until it leaves after Rules.UFOLifetime.
+type UFO struct {
+	ID       uint32
+	Trans    Vec2
+	Vel      Vec2
+	Rotation float64
+
+	age    time.Duration
+	reload time.Duration // time left until the next shot
+}
+
+func (u UFO) Shape() Circle {
+	return Circle{Center: u.Trans, Radius: UFORadius}
+}
+
+func (u UFO) EntityID() uint32 {
+	return u.ID
+}
+
+func (u UFO) Lerp(other UFO, t float64, world World) UFO {
+	u.Trans = world.Lerp(u.Trans, other.Trans, t)
+	u.Rotation = rlerp(u.Rotation, other.Rotation, t)
+	return u
+}
+
+func (u UFO) Encode(buf *bytes.Buffer) {
+	_ = binary.Write(buf, binary.BigEndian, u.ID)
+	encodeTrans(buf, u.Trans)
+	encodeRotation(buf, u.Rotation)
+}
+
+func (u *UFO) Decode(r *bytes.Reader) error {
+	fr := fieldReader{r: r}
+	fr.read(&u.ID)
+	u.Trans = fr.trans()
+	u.Rotation = fr.rotation()
+	return fr.err
+}
+
+const (
+	ufoMaxCount = 2
+	ufoAngVel   = 2
+
+	// UFOs hover around ufoStandoff away from their target rather than
+	// ramming it.
+	ufoStandoff = 350
+	// ufoAvoidRange is how close to an asteroid's edge UFOs start to veer
+	// away from it.
+	ufoAvoidRange = 150
+	ufoAimError   = 0.15 // rad
+	// ufoRetreat is how long UFOs take to leave once their time is up, after
+	// which they vanish even if they have not reached an edge.
+	ufoRetreat = 5 * time.Second
+)
+
+// updateUFOs spawns UFOs from the edges during the later waves, steers them
+// and has them shoot at the players, and resolves them running into players.
+func (s *State) updateUFOs(delta time.Duration) {
+	r := s.Rules
+	dt := delta.Seconds()
+
+	// UFO spawn
+	if s.Phase == PhaseWave && s.Wave >= r.UFOFirstWave {
+		s.ufoSpawn -= delta
+		if s.ufoSpawn <= 0 {
+			s.ufoSpawn = r.UFOInterval.Duration
+			if len(s.UFOs) < ufoMaxCount {
+				s.spawnUFO()
+			}
+		}
+	}
+
+	for i := range s.UFOs {
+		ufo := &s.UFOs[i]
+		ufo.age += delta
+		ufo.reload -= delta
+		target := s.nearestPlayer(ufo.Trans)
+		retreating := ufo.age > r.UFOLifetime.Duration
+
+		// UFO steering
+		desired := ufo.Vel.Normalize().Mul(r.UFOSpeed)
+		switch {
+		case retreating:
+			center := Vec2{X: s.World.Width / 2, Y: s.World.Height / 2}
+			desired = ufo.Trans.Sub(center).Normalize().Mul(r.UFOSpeed)
+		case target != nil:
+			toward := s.World.Delta(ufo.Trans, target.Trans)
+			if toward.Magnitude() > ufoStandoff {
+				desired = toward.Normalize().Mul(r.UFOSpeed)
+			} else {
+				// circle the target at a distance
+				desired = Vec2{X: -toward.Y, Y: toward.X}.Normalize().Mul(r.UFOSpeed)
+			}
+		}
+		for _, asteroid := range s.Asteroids {
+			away := s.World.Delta(asteroid.Trans, ufo.Trans)
+			gap := away.Magnitude() - asteroid.Size.Radius() - UFORadius
+			if gap < ufoAvoidRange {
+				weight := 2 * (1 - max(gap, 0)/ufoAvoidRange)
+				desired = desired.Add(away.Normalize().Mul(weight * r.UFOSpeed))
+			}
+		}
+		steer := desired.Sub(ufo.Vel)
+		if maxSteer := r.UFOAccel * dt; steer.Magnitude() > maxSteer {
+			steer = steer.Normalize().Mul(maxSteer)
+		}
+		ufo.Vel = ufo.Vel.Add(steer)
+		if ufo.Vel.Magnitude() > r.UFOSpeed {
+			ufo.Vel = ufo.Vel.Normalize().Mul(r.UFOSpeed)
+		}
+
+		// UFO movement
+		ufo.Trans = s.World.Wrapped(ufo.Vel.Mul(dt).Add(ufo.Trans))
+		ufo.Rotation = wrapAngle(ufoAngVel*dt + ufo.Rotation)
+
+		// UFO shooting
+		if target != nil && !retreating && ufo.reload <= 0 {
+			toward := s.World.Delta(ufo.Trans, target.Trans)
+			angle := math.Atan2(toward.Y, toward.X) + ufoAimError*(2*rand.Float64()-1)
+			s.Bullets = append(s.Bullets, Bullet{
+				ID:       s.nextBulletID,
+				Trans:    ufo.Trans,
+				Vel:      HeadVec2(angle).Mul(r.UFOBulletSpeed),
+				Rotation: wrapAngle(angle - 1.5*math.Pi),
+				Owner:    OwnerHostile,
+			})
+			s.nextBulletID++
+			ufo.reload = r.UFOFireInterval.Duration
+		}
+	}
+
+	// UFO disappearance
+	s.UFOs = slices.DeleteFunc(s.UFOs, func(ufo UFO) bool {
+		return s.World.Outside(ufo.Shape()) || ufo.age > r.UFOLifetime.Duration+ufoRetreat
+	})
+
+	// player-UFO collision check
+	for i := range s.Players {
+		player := &s.Players[i]
+		if player.Dead || player.Invulnerable > 0 {
+			continue
+		}
+		for iufo, ufo := range s.UFOs {
+			if s.World.Overlaps(player.Shape(), ufo.Shape()) {
+				s.UFOs = slices.Delete(s.UFOs, iufo, iufo+1)
+				s.crash(player)
+				break
+			}
+		}
+	}
+}
+
+// nearestPlayer returns the living player closest to v, or nil if there is
+// none.
+func (s *State) nearestPlayer(v Vec2) *Player {
+	var nearest *Player
+	nearestDist := math.Inf(1)
+	for i := range s.Players {
+		player := &s.Players[i]
+		if player.Dead {
+			continue
+		}
+		if d := s.World.Delta(v, player.Trans).Magnitude(); d < nearestDist {
+			nearest = player
+			nearestDist = d
+		}
+	}
+	return nearest
+}
+
+// spawnUFO spawns a UFO just outside a random edge of the world, heading
+// inwards.
+func (s *State) spawnUFO() {
+	var trans Vec2
+	switch rand.N(4) {
+	case 0:
+		trans = Vec2{X: s.World.Width * rand.Float64(), Y: -UFORadius}
+	case 1:
+		trans = Vec2{X: s.World.Width * rand.Float64(), Y: s.World.Height + UFORadius}
+	case 2:
+		trans = Vec2{X: -UFORadius, Y: s.World.Height * rand.Float64()}
+	case 3:
+		trans = Vec2{X: s.World.Width + UFORadius, Y: s.World.Height * rand.Float64()}
+	}
+	center := Vec2{X: s.World.Width / 2, Y: s.World.Height / 2}
+
+	s.UFOs = append(s.UFOs, UFO{
+		ID:     s.nextUFOID,
+		Trans:  trans,
+		Vel:    center.Sub(trans).Normalize().Mul(s.Rules.UFOSpeed),
+		reload: s.Rules.UFOFireInterval.Duration,
+	})
+	s.nextUFOID++
+}
@@ -0,0 +1,180 @@
+package state_test
+
+import (
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_Update_ufoShotDown(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Players[0].Trans = state.Vec2{X: 100, Y: 100}
+	s.Bullets = state.Entities[state.Bullet]{{
+		ID:    1,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2},
+		Vel:   state.Vec2{X: 0, Y: -1200},
+		Owner: s.Players[0].ID,
+	}}
+	s.UFOs = state.Entities[state.UFO]{{
+		ID:    100,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 60},
+	}}
+
+	s.Update(dt, nil)
+
+	assert.Empty(t, s.UFOs)
+	for _, bullet := range s.Bullets {
+		assert.NotEqual(t, s.Players[0].ID, bullet.Owner, "the bullet is spent on the UFO")
+	}
+	assert.Equal(t, s.Rules.UFOScore, s.Players[0].Score)
+	assert.Equal(t, s.Rules.UFOScore, s.TotalScore)
+}
+
+func TestState_Update_hostileBullets(t *testing.T) {
+	const dt = time.Second / 30
+
+	for _, mode := range []state.Mode{state.ModeCoop, state.ModeFreeForAll, state.ModeTeamDeathmatch} {
+		t.Run(mode.String(), func(t *testing.T) {
+			s := state.Init()
+			s.Mode = mode
+			s.AddPlayer("a")
+			s.Players[0].Invulnerable = 0
+			s.Players[0].Trans = state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 300}
+			s.Asteroids = state.Entities[state.Asteroid]{{
+				ID:    100,
+				Size:  state.AsteroidLarge,
+				Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 - 100},
+			}}
+			s.Bullets = state.Entities[state.Bullet]{{
+				ID:    1,
+				Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2},
+				Vel:   state.Vec2{X: 0, Y: -1200},
+				Owner: state.OwnerHostile,
+			}}
+
+			for range 10 {
+				s.Update(dt, nil)
+			}
+
+			assert.True(t, s.Players[0].Dead, "hostile bullets hurt players in every mode")
+			if assert.Len(t, s.Asteroids, 1) {
+				assert.Equal(t, uint32(100), s.Asteroids[0].ID, "hostile bullets fly through asteroids")
+			}
+			assert.Zero(t, s.TotalScore)
+		})
+	}
+}
+
+func TestState_Update_ufoHunts(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	s.AddPlayer("b")
+	near := state.Vec2{X: state.ScreenWidth/2 + 400, Y: state.ScreenHeight / 2}
+	s.Players[0].Trans = state.Vec2{X: 100, Y: 100}
+	s.Players[1].Trans = near
+	start := state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+	s.UFOs = state.Entities[state.UFO]{{ID: 100, Trans: start}}
+
+	var shots []state.Bullet
+	for range int(s.Rules.UFOFireInterval.Duration/dt) + 1 {
+		s.Players[0].Invulnerable = time.Hour
+		s.Players[1].Invulnerable = time.Hour
+		s.Update(dt, nil)
+		for _, bullet := range s.Bullets {
+			if bullet.Owner == state.OwnerHostile {
+				shots = append(shots, bullet)
+			}
+		}
+	}
+
+	if assert.Len(t, s.UFOs, 1) {
+		ufo := s.UFOs[0]
+		assert.Less(t, near.Sub(ufo.Trans).Magnitude(), near.Sub(start).Magnitude(), "the UFO closes in on the nearest player")
+	}
+	if assert.NotEmpty(t, shots) {
+		assert.Greater(t, shots[0].Vel.X, 0.0, "the UFO shoots at the nearest player")
+	}
+}
+
+func TestState_Update_ufoAvoidsAsteroids(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Players[0].Invulnerable = time.Hour
+	s.Players[0].Trans = state.Vec2{X: state.ScreenWidth - 100, Y: state.ScreenHeight / 2}
+	s.UFOs = state.Entities[state.UFO]{{
+		ID:    100,
+		Trans: state.Vec2{X: state.ScreenWidth/2 - 300, Y: state.ScreenHeight / 2},
+		Vel:   state.Vec2{X: 180, Y: 0},
+	}}
+	s.Asteroids = state.Entities[state.Asteroid]{{
+		ID:    100,
+		Size:  state.AsteroidLarge,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight/2 + 10},
+	}}
+
+	for range 60 {
+		s.Update(dt, nil)
+		if assert.Len(t, s.UFOs, 1) && assert.Len(t, s.Asteroids, 1) {
+			assert.False(t, s.World.Overlaps(s.UFOs[0].Shape(), s.Asteroids[0].Shape()))
+		}
+	}
+}
+
+func TestState_Update_ufoCrash(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	center := state.Vec2{X: state.ScreenWidth / 2, Y: state.ScreenHeight / 2}
+	s.Players[0].Invulnerable = 0
+	s.Players[0].Trans = center
+	s.UFOs = state.Entities[state.UFO]{{ID: 100, Trans: center}}
+
+	s.Update(dt, nil)
+
+	assert.True(t, s.Players[0].Dead)
+	assert.Empty(t, s.UFOs)
+}
+
+func TestState_Update_ufoSpawn(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.Phase = state.PhaseWave
+	s.Wave = s.Rules.UFOFirstWave - 1
+	for range s.Rules.UFOInterval.Duration/dt + 1 {
+		s.Update(dt, nil)
+		s.Phase = state.PhaseWave
+		s.Asteroids = nil
+	}
+	assert.Empty(t, s.UFOs, "no UFOs before the first UFO wave")
+
+	s.Wave = s.Rules.UFOFirstWave
+	for range s.Rules.UFOInterval.Duration/dt + 1 {
+		s.Update(dt, nil)
+		s.Phase = state.PhaseWave
+		s.Asteroids = nil
+	}
+	assert.Len(t, s.UFOs, 1)
+}
+
+func TestState_AddPlayer_skipsOwnerHostile(t *testing.T) {
+	s := state.Init()
+	for range 1 << 16 {
+		s.AddPlayer("a")
+		id, _ := s.PlayerID("a")
+		if id == 0 || id == state.OwnerHostile {
+			t.Fatalf("player given the reserved ID %d", id)
+		}
+		s.RemovePlayer("a")
+	}
+}
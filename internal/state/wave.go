@@ -0,0 +1,197 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Phase is the stage of the round the game is in.
+type Phase uint8
+
+const (
+	// PhaseBreather is the pause before the next wave.
+	PhaseBreather Phase = iota
+	// PhaseWave is when the asteroids of a wave are coming in.
+	PhaseWave
+	// PhaseGameOver is when every player is out of lives. The results are
+	// shown until the game restarts.
+	PhaseGameOver
+)
+
+var phaseNames = [...]string{
+	PhaseBreather: "breather",
+	PhaseWave:     "wave",
+	PhaseGameOver: "game over",
+}
+
+func (p Phase) String() string {
+	if int(p) < len(phaseNames) {
+		return phaseNames[p]
+	}
+	return fmt.Sprintf("Phase(%d)", p)
+}
+
+const asteroidDirRange = 0.75 * math.Pi
+
+// wave describes the asteroids thrown at the players during a wave. They get
+// more numerous, faster and more varied in size as the waves go on.
+type wave struct {
+	count    int
+	speed    float64
+	interval time.Duration
+	mix      [AsteroidLarge + 1]float64 // weights of picking each size
+}
+
+// waveOf returns the nth wave, starting from one.
+func (s *State) waveOf(n uint16) wave {
+	k := float64(n - 1)
+	return wave{
+		count:    min(4+2*int(n-1), 24),
+		speed:    s.Rules.AsteroidSpeed * min(1+0.12*k, 2.5),
+		interval: max(2*time.Second-time.Duration(k)*150*time.Millisecond, 500*time.Millisecond),
+		mix: [...]float64{
+			AsteroidSmall:  min(0.15*max(k-2, 0), 1),
+			AsteroidMedium: min(0.25*k, 1),
+			AsteroidLarge:  1,
+		},
+	}
+}
+
+func (w wave) size() AsteroidSize {
+	total := 0.0
+	for _, weight := range w.mix {
+		total += weight
+	}
+	x := total * rand.Float64()
+	for size, weight := range w.mix {
+		if x < weight {
+			return AsteroidSize(size)
+		}
+		x -= weight
+	}
+	return AsteroidLarge
+}
+
+// direct advances the phases of the round: it spawns the asteroids of each
+// wave, gives the players a breather in between, and ends the game once every
+// player is out of lives, restarting it after the results have been shown.
+func (s *State) direct(delta time.Duration) {
+	switch s.Phase {
+	case PhaseBreather:
+		s.PhaseTime -= delta
+		if s.PhaseTime > 0 {
+			break
+		}
+		s.Phase = PhaseWave
+		s.PhaseTime = 0
+		s.Wave++
+		s.waveLeft = s.waveOf(s.Wave).count
+		s.waveSpawn = 0
+
+	case PhaseWave:
+		w := s.waveOf(s.Wave)
+		s.waveSpawn -= delta
+		if s.waveLeft > 0 && s.waveSpawn <= 0 {
+			s.spawnAsteroid(w.size(), w.speed)
+			s.waveLeft--
+			s.waveSpawn = w.interval
+		}
+		if s.waveLeft == 0 && len(s.Asteroids) == 0 {
+			s.Phase = PhaseBreather
+			s.PhaseTime = s.Rules.WaveBreather.Duration
+		}
+
+	case PhaseGameOver:
+		s.PhaseTime -= delta
+		if s.PhaseTime <= 0 {
+			s.restart()
+		}
+		return
+	}
+
+	if s.outOfLives() {
+		s.Phase = PhaseGameOver
+		s.PhaseTime = s.Rules.GameOverResults.Duration
+	}
+}
+
+func (s *State) outOfLives() bool {
+	for _, player := range s.Players {
+		if !player.Dead || player.Lives > 0 {
+			return false
+		}
+	}
+	return len(s.Players) > 0
+}
+
+// restart starts the game over from the first wave, with everyone's score and
+// lives reset.
+func (s *State) restart() {
+	s.Phase = PhaseBreather
+	s.PhaseTime = s.Rules.WaveBreather.Duration
+	s.Wave = 0
+	s.waveLeft = 0
+	s.waveSpawn = 0
+
+	s.TotalScore = 0
+	s.Teams = [TeamCount]Team{}
+	s.Bullets = nil
+	s.Asteroids = nil
+	s.PowerUps = nil
+	s.powerUpSpawn = s.Rules.PowerUpInterval.Duration
+	s.UFOs = nil
+	s.ufoSpawn = s.Rules.UFOInterval.Duration
+	for i := range s.Players {
+		player := &s.Players[i]
+		player.Score = 0
+		player.Kills = 0
+		player.Deaths = 0
+		player.Lives = s.Rules.PlayerLives
+		s.respawn(player)
+	}
+}
+
+// spawnAsteroid spawns an asteroid randomly at the edges of the world.
+func (s *State) spawnAsteroid(size AsteroidSize, speed float64) {
+	const (
+		//               directions:
+		top    = iota //   up     = -π/2
+		bottom        //   down   =  π/2
+		left          //   left   = -π
+		right         //   right  =  0
+	)
+	// Asteroids spawn just outside the world, touching its edge, so that they
+	// slide in rather than pop into existence.
+	var trans Vec2
+	var dir float64
+	switch rand.N(4) {
+	case top:
+		trans.X = s.World.Width * rand.Float64()
+		trans.Y = -size.Radius()
+		dir = asteroidDirRange*(rand.Float64()-0.5) + 0.5*math.Pi
+	case bottom:
+		trans.X = s.World.Width * rand.Float64()
+		trans.Y = s.World.Height + size.Radius()
+		dir = asteroidDirRange*(rand.Float64()-0.5) - 0.5*math.Pi
+	case left:
+		trans.X = -size.Radius()
+		trans.Y = s.World.Height * rand.Float64()
+		dir = asteroidDirRange * (rand.Float64() - 0.5)
+	case right:
+		trans.X = s.World.Width + size.Radius()
+		trans.Y = s.World.Height * rand.Float64()
+		dir = asteroidDirRange*(rand.Float64()-0.5) - math.Pi
+	}
+
+	s.Asteroids = append(s.Asteroids, Asteroid{
+		ID:       s.nextAsteroidID,
+		Size:     size,
+		Trans:    trans,
+		Vel:      HeadVec2(dir).Mul(speed),
+		AngVel:   math.Pi * (rand.Float64() - 0.5),
+		Rotation: 2 * math.Pi * (rand.Float64() - 0.5),
+	})
+	s.nextAsteroidID++
+}
@@ -0,0 +1,96 @@
+package state_test
+
+import (
+	"bytes"
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_Update_waves(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	assert.Equal(t, state.PhaseBreather, s.Phase)
+	assert.Equal(t, uint16(0), s.Wave)
+
+	for s.Phase == state.PhaseBreather {
+		s.Update(dt, nil)
+	}
+	assert.Equal(t, state.PhaseWave, s.Phase)
+	assert.Equal(t, uint16(1), s.Wave)
+	assert.Empty(t, s.Asteroids)
+
+	// The first asteroid of the wave spawns right away, the others one by one.
+	s.Update(dt, nil)
+	assert.Len(t, s.Asteroids, 1)
+
+	// The wave only ends once every asteroid has spawned and is gone.
+	spawned := len(s.Asteroids)
+	for range 10 * 30 * 2 {
+		s.Asteroids = nil
+		s.Update(dt, nil)
+		spawned += len(s.Asteroids)
+		if s.Phase != state.PhaseWave {
+			break
+		}
+	}
+	assert.Equal(t, state.PhaseBreather, s.Phase)
+	assert.Equal(t, uint16(1), s.Wave)
+	assert.Equal(t, 4, spawned)
+}
+
+func TestState_Update_gameOver(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.AddPlayer("a")
+	s.Wave = 3
+	s.TotalScore = 42
+	s.Players[0].Score = 42
+	s.Players[0].Lives = 0
+	s.Players[0].Dead = true
+
+	s.Update(dt, nil)
+	assert.Equal(t, state.PhaseGameOver, s.Phase)
+	assert.True(t, s.PhaseTime > 0)
+	assert.Equal(t, uint32(42), s.TotalScore, "results are kept until the restart")
+
+	for s.Phase == state.PhaseGameOver {
+		s.Update(dt, nil)
+	}
+	assert.Equal(t, state.PhaseBreather, s.Phase)
+	assert.Equal(t, uint16(0), s.Wave)
+	assert.Equal(t, uint32(0), s.TotalScore)
+	assert.Equal(t, uint32(0), s.Players[0].Score)
+	assert.Equal(t, uint8(state.PlayerLives), s.Players[0].Lives)
+	assert.False(t, s.Players[0].Dead)
+}
+
+func TestState_Update_notGameOverWhileAlive(t *testing.T) {
+	s := state.Init()
+	s.AddPlayer("a")
+	s.AddPlayer("b")
+	s.Players[0].Lives = 0
+	s.Players[0].Dead = true
+
+	s.Update(time.Second/30, nil)
+	assert.Equal(t, state.PhaseBreather, s.Phase)
+}
+
+func TestState_Encode_phase(t *testing.T) {
+	s := state.Init()
+	s.Phase = state.PhaseGameOver
+	s.PhaseTime = 1500 * time.Millisecond
+	s.Wave = 7
+
+	var buf bytes.Buffer
+	s.Encode(&buf)
+	var decoded state.State
+	assert.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, s.Phase, decoded.Phase)
+	assert.Equal(t, s.PhaseTime, decoded.PhaseTime)
+	assert.Equal(t, s.Wave, decoded.Wave)
+}
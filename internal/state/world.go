@@ -0,0 +1,80 @@
+package state
+
+import "math"
+
+// World describes the playing field spanning from the origin to (Width,
+// Height).
+//
+// A wrapping world behaves like a torus: entities leaving through one edge come
+// back in through the opposite one instead of being stopped or removed, and
+// distances are measured the shortest way across the edges.
+type World struct {
+	Width, Height float64
+	Wrap          bool
+}
+
+// Wrapped returns v wrapped into the world if it wraps, and v as is otherwise.
+func (w World) Wrapped(v Vec2) Vec2 {
+	if !w.Wrap {
+		return v
+	}
+	v.X = wrapCoord(v.X, w.Width)
+	v.Y = wrapCoord(v.Y, w.Height)
+	return v
+}
+
+func wrapCoord(x, size float64) float64 {
+	x = math.Mod(x, size)
+	if x < 0 {
+		x += size
+	}
+	return x
+}
+
+// Delta returns the displacement from a to b.
+func (w World) Delta(a, b Vec2) Vec2 {
+	d := b.Sub(a)
+	if w.Wrap {
+		d.X -= w.Width * math.Round(d.X/w.Width)
+		d.Y -= w.Height * math.Round(d.Y/w.Height)
+	}
+	return d
+}
+
+// Lerp interpolates from a to b. In a wrapping world, this takes the shortest
+// path, which might cross an edge, rather than sweeping across the whole world.
+func (w World) Lerp(a, b Vec2, t float64) Vec2 {
+	if !w.Wrap {
+		return a.Lerp(b, t)
+	}
+	return w.Wrapped(a.Lerp(a.Add(w.Delta(a, b)), t))
+}
+
+// Confine returns the center that keeps c within the world, along with
+// whether each axis had to be clamped. Wrapping worlds never clamp.
+func (w World) Confine(c Circle) (center Vec2, clampedX, clampedY bool) {
+	if w.Wrap {
+		return w.Wrapped(c.Center), false, false
+	}
+	return c.Confine(w.Width, w.Height)
+}
+
+// Outside reports whether c has left the world for good, which never happens
+// in a wrapping world.
+func (w World) Outside(c Circle) bool {
+	return !w.Wrap && c.Outside(w.Width, w.Height)
+}
+
+// Overlaps reports whether a and b intersect, taking their copies across the
+// edges into account.
+func (w World) Overlaps(a, b Circle) bool {
+	a.Center = b.Center.Add(w.Delta(b.Center, a.Center))
+	return a.Overlaps(b)
+}
+
+// Sweep is like Circle.Sweep, but takes the copies of other across the edges
+// into account.
+func (w World) Sweep(c Circle, motion Vec2, other Circle) (float64, bool) {
+	c.Center = other.Center.Add(w.Delta(other.Center, c.Center))
+	return c.Sweep(motion, other)
+}
@@ -0,0 +1,83 @@
+package state_test
+
+import (
+	"multiplayer/internal/state"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorld_Lerp(t *testing.T) {
+	bounded := state.World{Width: 100, Height: 50, Wrap: false}
+	wrapping := state.World{Width: 100, Height: 50, Wrap: true}
+
+	tests := []struct {
+		name     string
+		world    state.World
+		a, b     state.Vec2
+		t        float64
+		expected state.Vec2
+	}{
+		{"bounded across", bounded, state.Vec2{X: 95, Y: 25}, state.Vec2{X: 5, Y: 25}, 0.5, state.Vec2{X: 50, Y: 25}},
+		{"wrapping within", wrapping, state.Vec2{X: 40, Y: 25}, state.Vec2{X: 60, Y: 25}, 0.5, state.Vec2{X: 50, Y: 25}},
+		{"wrapping across right edge", wrapping, state.Vec2{X: 95, Y: 25}, state.Vec2{X: 5, Y: 25}, 0.25, state.Vec2{X: 97.5, Y: 25}},
+		{"wrapping across left edge", wrapping, state.Vec2{X: 5, Y: 25}, state.Vec2{X: 95, Y: 25}, 0.75, state.Vec2{X: 97.5, Y: 25}},
+		{"wrapping across bottom edge", wrapping, state.Vec2{X: 10, Y: 45}, state.Vec2{X: 10, Y: 5}, 0.5, state.Vec2{X: 10, Y: 0}},
+		{"wrapping across corner", wrapping, state.Vec2{X: 99, Y: 49}, state.Vec2{X: 3, Y: 3}, 0.25, state.Vec2{X: 0, Y: 0}},
+		{"wrapping at start", wrapping, state.Vec2{X: 95, Y: 25}, state.Vec2{X: 5, Y: 25}, 0, state.Vec2{X: 95, Y: 25}},
+		{"wrapping at end", wrapping, state.Vec2{X: 95, Y: 25}, state.Vec2{X: 5, Y: 25}, 1, state.Vec2{X: 5, Y: 25}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := test.world.Lerp(test.a, test.b, test.t)
+			assert.InDelta(t, test.expected.X, actual.X, 1e-9)
+			assert.InDelta(t, test.expected.Y, actual.Y, 1e-9)
+		})
+	}
+}
+
+func TestWorld_Overlaps(t *testing.T) {
+	bounded := state.World{Width: 100, Height: 50, Wrap: false}
+	wrapping := state.World{Width: 100, Height: 50, Wrap: true}
+
+	a := state.Circle{Center: state.Vec2{X: 2, Y: 25}, Radius: 5}
+	b := state.Circle{Center: state.Vec2{X: 97, Y: 26}, Radius: 5}
+	assert.False(t, bounded.Overlaps(a, b))
+	assert.True(t, wrapping.Overlaps(a, b))
+	assert.True(t, wrapping.Overlaps(b, a))
+}
+
+func TestState_Update_wrap(t *testing.T) {
+	const dt = time.Second / 30
+
+	s := state.Init()
+	s.World.Wrap = true
+	s.Bullets = []state.Bullet{{
+		ID:    1,
+		Trans: state.Vec2{X: state.ScreenWidth / 2, Y: 10},
+		Vel:   state.Vec2{X: 0, Y: -1200},
+	}}
+	s.Asteroids = []state.Asteroid{{
+		ID:    100,
+		Size:  state.AsteroidSmall,
+		Trans: state.Vec2{X: 10, Y: state.ScreenHeight / 2},
+		Vel:   state.Vec2{X: -600, Y: 0},
+	}}
+
+	s.Update(dt, nil)
+
+	if assert.Len(t, s.Bullets, 1) {
+		assert.InDelta(t, state.ScreenHeight-30, s.Bullets[0].Trans.Y, 1e-3)
+	}
+	var asteroid *state.Asteroid
+	for i := range s.Asteroids {
+		if s.Asteroids[i].ID == 100 {
+			asteroid = &s.Asteroids[i]
+		}
+	}
+	if assert.NotNil(t, asteroid) {
+		assert.InDelta(t, state.ScreenWidth-10, asteroid.Trans.X, 1e-3)
+	}
+}